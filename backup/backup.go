@@ -0,0 +1,550 @@
+// Package backup dumps a MySQL database to a directory or a single sql file and loads it back,
+// the command line tool is a wrapper of it:
+//
+//	db, _ := sql.Open("mysql", "root:pass@tcp(127.0.0.1:3306)/test?charset=utf8")
+//	res, err := backup.Dump(ctx,
+//		backup.WithDB(db, "test"),
+//		backup.WithStorage(common.NewLocalStorage("/backup/test")),
+//		backup.WithTableFunc(func(e backup.TableEvent) { ... }),
+//	)
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// Version is written to the header of the single file dumps.
+var Version = "dev"
+
+// Data formats.
+const (
+	FormatSQL   = formatSQL
+	FormatCSV   = formatCSV
+	FormatTSV   = formatTSV
+	FormatJSONL = formatJSONL
+)
+
+// TableEvent is passed to the table callback when a table changes its status.
+type TableEvent struct {
+	// Stage is dumping, restoring or copying.
+	Stage string
+	TableProgress
+}
+
+// Result of a run.
+type Result struct {
+	Database string
+	Bytes    uint64
+	Rows     uint64
+	Elapsed  time.Duration
+	// Manifest of the dump written or loaded, nil for the single files.
+	Manifest *common.Manifest
+}
+
+// Options of a run, set by the Option functions.
+type Options struct {
+	ctx    context.Context
+	args   common.Args
+	db     *sql.DB
+	log    *xlog.Log
+	writer io.Writer
+	reader io.Reader
+	size   int64
+
+	subset     []SubsetRoot
+	watermarks map[string]string
+	previous   *common.Manifest
+}
+
+// Option sets the options of a run.
+type Option func(o *Options)
+
+// WithDB sets the database to dump or load into, db must be connected to it.
+func WithDB(db *sql.DB, database string) Option {
+	return func(o *Options) {
+		o.db = db
+		o.args.Database = database
+	}
+}
+
+// WithLogger sets the logger, the standard output by default.
+func WithLogger(log *xlog.Log) Option {
+	return func(o *Options) { o.log = log }
+}
+
+// WithStorage sets the dump directory.
+func WithStorage(storage common.Storage) Option {
+	return func(o *Options) {
+		o.args.Storage = storage
+		o.args.Outdir = storage.String()
+	}
+}
+
+// WithWriter sets the single sql file to dump to, instead of a dump directory.
+func WithWriter(w io.Writer) Option {
+	return func(o *Options) { o.writer = w }
+}
+
+// WithReader sets the single sql file to load, size is used by the progress, 0 if unknown.
+func WithReader(r io.Reader, size int64) Option {
+	return func(o *Options) {
+		o.reader = r
+		o.size = size
+	}
+}
+
+// WithThreads sets the number of the workers, 16 by default.
+func WithThreads(n int) Option {
+	return func(o *Options) { o.args.Threads = n }
+}
+
+// WithChunkSize sets the size of the data files in MB, 128 by default.
+func WithChunkSize(mb int) Option {
+	return func(o *Options) { o.args.ChunksizeInMB = mb }
+}
+
+// WithStmtSize sets the size of the INSERT statements in bytes, 1000000 by default. It's capped to the
+// max_allowed_packet of the server, the driver must allow it too, e.g. by maxAllowedPacket=0 in the DSN.
+func WithStmtSize(size int) Option {
+	return func(o *Options) { o.args.StmtSize = size }
+}
+
+// WithFormat sets the format of the data files, FormatSQL by default.
+func WithFormat(format string) Option {
+	return func(o *Options) { o.args.Format = format }
+}
+
+// WithExclude sets the tables whose data is not dumped, separated by ','.
+func WithExclude(tables string) Option {
+	return func(o *Options) { o.args.ExcludeTables = tables }
+}
+
+// WithVerify sets to checksum the tables on dump, and compare them after load.
+func WithVerify(verify bool) Option {
+	return func(o *Options) { o.args.Verify = verify }
+}
+
+// WithNoData sets to skip the rows of the tables, only the schema is dumped or loaded.
+func WithNoData(skip bool) Option {
+	return func(o *Options) { o.args.NoData = skip }
+}
+
+// WithNoSchema sets to skip the tables, and the triggers and events of a loaded sql file, the rows
+// are loaded into the existing tables.
+func WithNoSchema(skip bool) Option {
+	return func(o *Options) { o.args.NoSchema = skip }
+}
+
+// WithNoRoutines sets to skip the functions and procedures.
+func WithNoRoutines(skip bool) Option {
+	return func(o *Options) { o.args.NoRoutines = skip }
+}
+
+// WithNoViews sets to skip the views.
+func WithNoViews(skip bool) Option {
+	return func(o *Options) { o.args.NoViews = skip }
+}
+
+// WithIgnoreChecksum sets to only warn about the files not matching the manifest.
+func WithIgnoreChecksum(ignore bool) Option {
+	return func(o *Options) { o.args.IgnoreChecksum = ignore }
+}
+
+// WithMasking sets the masking rules of the dump.
+func WithMasking(m *common.Masking) Option {
+	return func(o *Options) { o.args.Masking = m }
+}
+
+// WithCipher sets the cipher of the dump files.
+func WithCipher(c *common.Cipher) Option {
+	return func(o *Options) { o.args.Cipher = c }
+}
+
+// WithRenames sets the rename rules of the load.
+func WithRenames(r *common.Renames) Option {
+	return func(o *Options) { o.args.Renames = r }
+}
+
+// WithSubset sets to dump the rows of the roots and the rows related to them by foreign keys.
+func WithSubset(roots []SubsetRoot) Option {
+	return func(o *Options) { o.subset = roots }
+}
+
+// WithWatermarks sets the watermark columns of the tables, table '*' for every table having the column.
+func WithWatermarks(columns map[string]string) Option {
+	return func(o *Options) { o.watermarks = columns }
+}
+
+// WithIncrementalFrom sets to only dump the rows beyond the watermarks of the previous dump.
+func WithIncrementalFrom(name string, previous *common.Manifest) Option {
+	return func(o *Options) {
+		o.args.IncrementalFrom = name
+		o.previous = previous
+	}
+}
+
+// WithProgress sets the progress output, format is text(logged) or json(lines to w),
+// the status with every table is written to file if it's not empty.
+func WithProgress(format string, w io.Writer, file string) Option {
+	return func(o *Options) {
+		o.args.ProgressFormat = format
+		o.args.ProgressOut = w
+		o.args.ProgressFile = file
+	}
+}
+
+// WithHooks sets the hooks run before/after the run and each table.
+func WithHooks(h *common.Hooks) Option {
+	return func(o *Options) { o.args.Hooks = h }
+}
+
+// WithRetries sets the max retries of a statement or chunk failing with a transient error, and of
+// the whole run, 5 and 100 by default.
+func WithRetries(n int, budget int) Option {
+	return func(o *Options) {
+		o.args.Retries = n
+		o.args.RetryBudget = int64(budget)
+	}
+}
+
+// WithInterval sets the interval of the progress reports, 10s by default.
+func WithInterval(d time.Duration) Option {
+	return func(o *Options) { o.args.IntervalMs = int(d / time.Millisecond) }
+}
+
+// WithProgressFunc sets the callback of every progress report.
+func WithProgressFunc(fn func(s *ProgressStatus)) Option {
+	return func(o *Options) { o.args.OnProgress = fn }
+}
+
+// WithTableFunc sets the callback of the table status changes, it's called with the progress
+// locked, so it must return quickly.
+func WithTableFunc(fn func(e TableEvent)) Option {
+	return func(o *Options) {
+		o.args.OnTable = func(stage string, t TableProgress) { fn(TableEvent{Stage: stage, TableProgress: t}) }
+	}
+}
+
+func newOptions(ctx context.Context, opts []Option) *Options {
+	o := &Options{
+		log: xlog.NewStdLog(xlog.Level(xlog.INFO)),
+		args: common.Args{
+			Threads:        16,
+			ChunksizeInMB:  128,
+			StmtSize:       1000000,
+			Format:         formatSQL,
+			IntervalMs:     10 * 1000,
+			ProgressFormat: "text",
+			ProgressOut:    os.Stdout,
+			Retries:        5,
+			RetryBudget:    100,
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	o.ctx, o.args.Context = ctx, ctx
+	return o
+}
+
+// CheckOptions returns the error of the options that can't be used together, Dump, Load and Copy
+// check their options with it too.
+func CheckOptions(opts ...Option) error {
+	return newOptions(context.Background(), opts).check()
+}
+
+func (o *Options) check() error {
+	a := &o.args
+	switch {
+	case a.NoData && a.NoSchema && a.NoRoutines && a.NoViews:
+		return errors.New("no data, no schema, no routines and no views leave nothing to do")
+	case a.Verify && a.NoData:
+		return errors.New("verify can't be used with no data, there are no rows to checksum")
+	case a.Verify && a.Masking != nil:
+		return errors.New("verify can't be used with masking, the masked data never matches the checksums")
+	case a.Verify && o.subset != nil:
+		return errors.New("verify can't be used with a subset, the checksums are of the whole tables")
+	case a.Verify && o.previous != nil:
+		return errors.New("verify can't be used with an incremental dump, the checksums are of the whole tables")
+	case o.previous != nil && o.subset != nil:
+		return errors.New("an incremental dump can't be a subset")
+	case o.previous != nil && o.writer != nil:
+		return errors.New("an incremental dump can't be a single file, it has no manifest")
+	case o.watermarks != nil && o.writer != nil:
+		return errors.New("the watermarks can't be recorded in a single file, it has no manifest")
+	}
+	return nil
+}
+
+func (o *Options) engine() (*sqlEngine, error) {
+	if o.db == nil {
+		return nil, errors.New("no database, set WithDB")
+	}
+	return newEngine(o.db, o.args.Database)
+}
+
+func (o *Options) result(t time.Time) *Result {
+	return &Result{
+		Database: o.args.Database,
+		Bytes:    o.args.Allbytes,
+		Rows:     o.args.Allrows,
+		Elapsed:  time.Since(t),
+		Manifest: o.args.Manifest,
+	}
+}
+
+// failure holds the first panic of a run, the workers cancel the run with it.
+type failure struct {
+	mu     sync.Mutex
+	err    error
+	cancel context.CancelFunc
+}
+
+// start used to make the context of the run canceled by the first failure.
+func (f *failure) start(args *common.Args) {
+	args.Context, f.cancel = context.WithCancel(args.Context)
+}
+
+// catch used to record the panic of a worker, it must be deferred.
+func (f *failure) catch() {
+	if r := recover(); r != nil {
+		f.set(r)
+	}
+}
+
+func (f *failure) set(r interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		if err, ok := r.(error); ok {
+			f.err = err
+		} else {
+			f.err = fmt.Errorf("%v", r)
+		}
+	}
+	f.cancel()
+}
+
+// check used to raise the failure of the workers in the caller.
+func (f *failure) check() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		panic(f.err)
+	}
+}
+
+// run used to call fn with the failures of the workers returned as the error.
+func run(args *common.Args, fn func(f *failure)) (err error) {
+	f := &failure{}
+	f.start(args)
+	defer func() {
+		if r := recover(); r != nil {
+			f.set(r)
+		}
+		f.cancel()
+		err = f.err
+	}()
+	fn(f)
+	f.check()
+	return nil
+}
+
+// run used to call fn in run with the hooks of the stage, sql hooks run on the db of the options.
+func (o *Options) run(stage string, target *sql.DB, fn func(f *failure)) error {
+	return fireRunHooks(o.ctx, o.log, &o.args, o.db, target, stage, func() error {
+		return run(&o.args, fn)
+	})
+}
+
+// checkContext used to stop the worker if the run is canceled.
+func checkContext(args *common.Args) {
+	common.AssertNil(args.Context.Err())
+}
+
+// Dump used to dump the database to the storage, or to the writer as a single sql file.
+func Dump(ctx context.Context, opts ...Option) (*Result, error) {
+	o := newOptions(ctx, opts)
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	if o.args.Database == "" {
+		return nil, errors.New("no database to dump")
+	}
+	if o.writer == nil && o.args.Storage == nil {
+		return nil, errors.New("no storage or writer to dump to")
+	}
+	engine, err := o.engine()
+	if err != nil {
+		return nil, err
+	}
+
+	t := time.Now()
+	err = o.run("dumping", nil, func(f *failure) {
+		if o.subset != nil {
+			o.args.Filters = subsetFilters(o.log, &o.args, engine, o.subset)
+		}
+		if o.watermarks != nil || o.previous != nil {
+			var filters map[string]*common.TableFilter
+			o.args.Watermarks, filters = watermarkFilters(o.log, &o.args, engine, o.watermarks, o.previous)
+			o.args.Filters = mergeFilters(o.args.Filters, filters)
+		}
+		if o.writer != nil {
+			dumpStream(o.log, &o.args, engine, o.writer, f)
+			return
+		}
+		dumpDir(o.log, &o.args, engine, f)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.result(t), nil
+}
+
+// SourceDatabase returns the name of the dumped database.
+func SourceDatabase(storage common.Storage, cipher *common.Cipher) (string, error) {
+	data, err := common.ReadAll(storage, "dbname")
+	if err != nil {
+		return "", err
+	}
+	if data, err = cipher.Open(data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Load used to load the dump of the storage, or the single sql file of the reader.
+// The dump is loaded into the database of WithDB, where the database is renamed to.
+func Load(ctx context.Context, opts ...Option) (*Result, error) {
+	o := newOptions(ctx, opts)
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	if o.reader == nil && o.args.Storage == nil {
+		return nil, errors.New("no storage or reader to load from")
+	}
+	engine, err := o.engine()
+	if err != nil {
+		return nil, err
+	}
+	// the views and routines are renamed to the database of WithDB, without rules too.
+	if o.args.Renames == nil {
+		o.args.Renames, _ = common.ParseRenames("")
+	}
+
+	t := time.Now()
+	if o.reader != nil {
+		err = o.run("restoring", nil, func(f *failure) { loadStream(o.log, &o.args, engine, o.reader, o.size, f) })
+		if err != nil {
+			return nil, err
+		}
+		return o.result(t), nil
+	}
+
+	if o.args.SourceDatabase, err = SourceDatabase(o.args.Storage, o.args.Cipher); err != nil {
+		return nil, err
+	}
+	if o.args.Database == "" {
+		o.args.Database = o.args.Renames.Database(o.args.SourceDatabase)
+	}
+	o.args.Renames.SetDefault(o.args.SourceDatabase, o.args.Database)
+	err = o.run("restoring", nil, func(f *failure) {
+		loadDir(o.log, &o.args, engine, f)
+		if o.args.Verify && !verifyRestore(o.log, &o.args, engine) {
+			panic(errors.New("verify failed"))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return o.result(t), nil
+}
+
+// Copy used to copy the database to target without intermediate files, target must be
+// connected to the database to copy to. The references to the source database in the views and
+// routines are renamed to the target one, along with the rules of WithRenames.
+func Copy(ctx context.Context, target *sql.DB, opts ...Option) (*Result, error) {
+	o := newOptions(ctx, opts)
+	if err := o.check(); err != nil {
+		return nil, err
+	}
+	o.args.Format = formatSQL
+	engine, err := o.engine()
+	if err != nil {
+		return nil, err
+	}
+	to, err := newEngine(target, "")
+	if err != nil {
+		return nil, err
+	}
+	var targetDB string
+	if err := target.QueryRowContext(ctx, "SELECT IFNULL(DATABASE(), '')").Scan(&targetDB); err != nil {
+		return nil, err
+	}
+	if targetDB == "" {
+		return nil, errors.New("the target is not connected to a database")
+	}
+	if o.args.Renames == nil {
+		o.args.Renames, _ = common.ParseRenames("")
+	}
+	o.args.SourceDatabase = o.args.Database
+	o.args.Renames.SetDefault(o.args.SourceDatabase, targetDB)
+
+	t := time.Now()
+	if err := o.run("copying", target, func(f *failure) { copyDatabase(o.log, &o.args, engine, to, f) }); err != nil {
+		return nil, err
+	}
+	return o.result(t), nil
+}
+
+// Verify used to check the files of the dump in the storage against its manifest, and the
+// checksums of the tables against the database if WithDB is set.
+func Verify(ctx context.Context, opts ...Option) (*Result, error) {
+	o := newOptions(ctx, opts)
+	if o.args.Storage == nil {
+		return nil, errors.New("no storage to verify")
+	}
+	manifest, err := common.ReadManifest(o.args.Storage, o.args.Cipher)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("%s not found in %s", common.ManifestName, o.args.Storage)
+	}
+	o.args.Manifest = manifest
+
+	t := time.Now()
+	errs := manifest.Verify(o.args.Storage)
+	for _, err := range errs {
+		o.log.Error("verify.file.error:%v", err)
+	}
+	o.log.Info("verify.files[%d].failed[%d]", len(manifest.Files), len(errs))
+	if len(errs) > 0 {
+		return nil, errors.New("verify failed")
+	}
+	if o.db == nil {
+		return o.result(t), nil
+	}
+
+	if o.args.Database == "" {
+		o.args.Database = manifest.Database
+	}
+	engine, err := o.engine()
+	if err != nil {
+		return nil, err
+	}
+	if !verifyRestore(o.log, &o.args, engine) {
+		return nil, errors.New("verify failed")
+	}
+	return o.result(t), nil
+}
@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// answerChecksum used to answer the checksum queries of the table t1.
+func answerChecksum(f *fakeDB) {
+	f.answer("SELECT COLUMN_NAME", []string{"COLUMN_NAME"}, []driver.Value{[]byte("id")}, []driver.Value{[]byte("name")})
+	f.answer("SELECT COUNT(*)", []string{"cnt", "crc"}, []driver.Value{[]byte("2"), []byte("123456")})
+}
+
+// ranStatements returns the statements run on the fake database, without the queries.
+func ranStatements(f *fakeDB) []string {
+	var stmts []string
+	for _, s := range f.statements() {
+		if s = s[3:]; !strings.HasPrefix(s, "SELECT ") && !strings.HasPrefix(s, "SHOW ") {
+			stmts = append(stmts, s)
+		}
+	}
+	return stmts
+}
+
+func containsAll(t *testing.T, stmts []string, want []string) {
+	for _, w := range want {
+		found := false
+		for _, s := range stmts {
+			found = found || s == w
+		}
+		if !found {
+			t.Errorf("statement %q didn't run: %q", w, stmts)
+		}
+	}
+}
+
+func TestDumpLoadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cipher, err := common.NewPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := xlog.NewXLog(ioutil.Discard)
+	storage := common.NewLocalStorage(dir)
+
+	source, sdb := newFakeDB(t)
+	defer sdb.Close()
+	source.answerSource()
+	answerChecksum(source)
+	var tables []string
+	res, err := Dump(context.Background(), WithDB(sdb, "db"), WithStorage(storage), WithLogger(log), WithThreads(2),
+		WithChunkSize(1), WithCipher(cipher), WithVerify(true), WithProgress("json", ioutil.Discard, ""),
+		WithTableFunc(func(e TableEvent) {
+			if e.Status == statusDone {
+				tables = append(tables, e.Stage+":"+e.Name)
+			}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Database != "db" || res.Rows != 2 || res.Manifest == nil {
+		t.Fatalf("Dump = %+v", res)
+	}
+	if c := res.Manifest.Checksums["t1"]; c == nil || c.Rows != 2 || c.Checksum != "123456" {
+		t.Errorf("checksum of t1 = %+v", c)
+	}
+	var files []string
+	for _, f := range res.Manifest.Files {
+		files = append(files, f.Name)
+	}
+	sort.Strings(files)
+	if want := "dbname,f1-function.sql,p1-procedure.sql,t1-table.sql,t1.00001.sql,v1-view.sql"; strings.Join(files, ",") != want {
+		t.Errorf("files = %v, want %s", files, want)
+	}
+	if len(tables) != 1 || tables[0] != "dumping:t1" {
+		t.Errorf("table events = %v", tables)
+	}
+	if data, err := ioutil.ReadFile(dir + "/t1.00001.sql"); err != nil || bytes.Contains(data, []byte("INSERT")) {
+		t.Errorf("t1.00001.sql is not encrypted: %v", err)
+	}
+
+	target, tdb := newFakeDB(t)
+	defer tdb.Close()
+	target.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+	answerChecksum(target)
+	res, err = Load(context.Background(), WithDB(tdb, "db2"), WithStorage(storage), WithLogger(log), WithThreads(2), WithCipher(cipher), WithVerify(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Database != "db2" {
+		t.Errorf("Load database = %s, want db2", res.Database)
+	}
+	containsAll(t, ranStatements(target), []string{
+		"CREATE TABLE `t1` (\n  `id` int NOT NULL,\n  `name` varchar(10),\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB;\n",
+		"INSERT INTO `t1`(`id`, `name`) VALUES\n(1, 'a'),\n(2, 'b')",
+		"CREATE DEFINER=`root`@`%` PROCEDURE `p1`()\nBEGIN\n  DELETE FROM `db2`.`t1` WHERE id = 0;\nEND;\n",
+		"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v1` AS select `db2`.`t1`.`id` AS `id` from `db2`.`t1`;\n",
+	})
+
+	// the dump can't be loaded without its key.
+	if _, err := Load(context.Background(), WithDB(tdb, "db2"), WithStorage(storage), WithLogger(log)); err == nil {
+		t.Error("Load of an encrypted dump without the cipher: want error")
+	}
+}
+
+func TestDumpLoadStream(t *testing.T) {
+	log := xlog.NewXLog(ioutil.Discard)
+	source, sdb := newFakeDB(t)
+	defer sdb.Close()
+	source.answerSource()
+	var buf bytes.Buffer
+	if _, err := Dump(context.Background(), WithDB(sdb, "db"), WithWriter(&buf), WithLogger(log), WithThreads(2), WithNoViews(true)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "VIEW `v1`") || !strings.Contains(buf.String(), "PROCEDURE `p1`") {
+		t.Errorf("stream:\n%s", buf.String())
+	}
+
+	target, tdb := newFakeDB(t)
+	defer tdb.Close()
+	target.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+	res, err := Load(context.Background(), WithDB(tdb, "db2"), WithReader(&buf, int64(buf.Len())), WithLogger(log), WithThreads(2), WithStmtSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Database != "db2" {
+		t.Errorf("Load database = %s, want db2", res.Database)
+	}
+	stmts := ranStatements(target)
+	containsAll(t, stmts, []string{
+		"USE `db2`",
+		"CREATE TABLE `t1` (\n  `id` int NOT NULL,\n  `name` varchar(10),\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+		"INSERT INTO `t1`(`id`, `name`) VALUES\n(1, 'a'),\n(2, 'b')",
+	})
+	for _, s := range stmts {
+		if strings.Contains(s, "`db`") {
+			t.Errorf("statement %q names the source database", s)
+		}
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	masking := &common.Masking{}
+	previous := common.NewManifest("db")
+	tests := []struct {
+		name string
+		opts []Option
+		err  bool
+	}{
+		{"none", nil, false},
+		{"verify", []Option{WithVerify(true)}, false},
+		{"nothing to do", []Option{WithNoData(true), WithNoSchema(true), WithNoRoutines(true), WithNoViews(true)}, true},
+		{"schema only", []Option{WithNoData(true), WithNoRoutines(true), WithNoViews(true)}, false},
+		{"verify no data", []Option{WithVerify(true), WithNoData(true)}, true},
+		{"verify masking", []Option{WithVerify(true), WithMasking(masking)}, true},
+		{"masking", []Option{WithMasking(masking)}, false},
+		{"verify subset", []Option{WithVerify(true), WithSubset([]SubsetRoot{{table: "t", where: "id = 1"}})}, true},
+		{"verify incremental", []Option{WithVerify(true), WithIncrementalFrom("prev", previous)}, true},
+		{"verify watermarks", []Option{WithVerify(true), WithWatermarks(map[string]string{"*": "updated_at"})}, false},
+		{"incremental subset", []Option{WithIncrementalFrom("prev", previous), WithSubset([]SubsetRoot{{table: "t", percent: 5}})}, true},
+		{"incremental single file", []Option{WithIncrementalFrom("prev", previous), WithWriter(ioutil.Discard)}, true},
+		{"watermarks single file", []Option{WithWatermarks(map[string]string{"*": "updated_at"}), WithWriter(ioutil.Discard)}, true},
+		{"incremental", []Option{WithIncrementalFrom("prev", previous)}, false},
+	}
+	for _, tt := range tests {
+		if err := CheckOptions(tt.opts...); (err != nil) != tt.err {
+			t.Errorf("%s: CheckOptions = %v, want error %v", tt.name, err, tt.err)
+		}
+	}
+
+	// the runs check their options before any query.
+	f, db := newFakeDB(t)
+	defer db.Close()
+	opts := []Option{WithDB(db, "db"), WithLogger(xlog.NewXLog(ioutil.Discard)), WithStorage(common.NewLocalStorage("unused")), WithVerify(true), WithMasking(masking)}
+	if _, err := Dump(context.Background(), opts...); err == nil {
+		t.Error("Dump with verify and masking: want error")
+	}
+	if _, err := Copy(context.Background(), db, opts...); err == nil {
+		t.Error("Copy with verify and masking: want error")
+	}
+	if stmts := f.statements(); len(stmts) != 0 {
+		t.Errorf("statements = %q, want none", stmts)
+	}
+}
+
+// skipTests are the options skipping parts of the fake source db, with the parts left.
+var skipTests = []struct {
+	name  string
+	opts  []Option
+	files string
+	kinds string
+}{
+	{"all", nil, "dbname,f1-function.sql,p1-procedure.sql,t1-table.sql,t1.00001.sql,v1-view.sql", "data,routine,table,view"},
+	{"no data", []Option{WithNoData(true)}, "dbname,f1-function.sql,p1-procedure.sql,t1-table.sql,v1-view.sql", "routine,table,view"},
+	{"no schema", []Option{WithNoSchema(true)}, "dbname,f1-function.sql,p1-procedure.sql,t1.00001.sql,v1-view.sql", "data,routine,view"},
+	{"no routines", []Option{WithNoRoutines(true)}, "dbname,t1-table.sql,t1.00001.sql,v1-view.sql", "data,table,view"},
+	{"no views", []Option{WithNoViews(true)}, "dbname,f1-function.sql,p1-procedure.sql,t1-table.sql,t1.00001.sql", "data,routine,table"},
+}
+
+// statementKinds returns the sorted kinds of the objects the statements create or fill.
+func statementKinds(stmts []string) string {
+	kinds := map[string]bool{}
+	for _, s := range stmts {
+		switch {
+		case strings.HasPrefix(s, "INSERT INTO"):
+			kinds["data"] = true
+		case strings.HasPrefix(s, "CREATE TABLE"):
+			kinds["table"] = true
+		case strings.HasPrefix(s, "CREATE ") && strings.Contains(s, " VIEW "):
+			kinds["view"] = true
+		case strings.HasPrefix(s, "CREATE ") && (strings.Contains(s, " FUNCTION ") || strings.Contains(s, " PROCEDURE ")):
+			kinds["routine"] = true
+		}
+	}
+	var names []string
+	for k := range kinds {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// dumpTemp returns a dump of the fake source db with the options in a temporary directory.
+func dumpTemp(t *testing.T, opts ...Option) (string, *Result) {
+	dir, err := ioutil.TempDir("", "dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, sdb := newFakeDB(t)
+	defer sdb.Close()
+	source.answerSource()
+	opts = append([]Option{WithDB(sdb, "db"), WithStorage(common.NewLocalStorage(dir)), WithLogger(xlog.NewXLog(ioutil.Discard)), WithThreads(2)}, opts...)
+	res, err := Dump(context.Background(), opts...)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return dir, res
+}
+
+func TestDumpSkip(t *testing.T) {
+	for _, tt := range skipTests {
+		dir, res := dumpTemp(t, tt.opts...)
+		os.RemoveAll(dir)
+		var files []string
+		for _, f := range res.Manifest.Files {
+			files = append(files, f.Name)
+		}
+		sort.Strings(files)
+		if got := strings.Join(files, ","); got != tt.files {
+			t.Errorf("%s: files = %s, want %s", tt.name, got, tt.files)
+		}
+	}
+}
+
+func TestLoadSkip(t *testing.T) {
+	dir, _ := dumpTemp(t)
+	defer os.RemoveAll(dir)
+	log := xlog.NewXLog(ioutil.Discard)
+	storage := common.NewLocalStorage(dir)
+
+	files := loadFiles(log, storage)
+	for _, tt := range []struct {
+		kind  string
+		files []string
+		want  string
+	}{
+		{"tables", files.tables, "t1-table.sql"},
+		{"functions", files.functions, "f1-function.sql"},
+		{"procedures", files.procedures, "p1-procedure.sql"},
+		{"views", files.views, "v1-view.sql"},
+		{"datas", files.datas, "t1.00001.sql"},
+	} {
+		if got := strings.Join(tt.files, ","); got != tt.want {
+			t.Errorf("loadFiles %s = %s, want %s", tt.kind, got, tt.want)
+		}
+	}
+
+	for _, tt := range skipTests {
+		target, tdb := newFakeDB(t)
+		target.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+		opts := append([]Option{WithDB(tdb, "db2"), WithStorage(storage), WithLogger(log), WithThreads(2)}, tt.opts...)
+		if _, err := Load(context.Background(), opts...); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := statementKinds(ranStatements(target)); got != tt.kinds {
+			t.Errorf("%s: loaded %s, want %s", tt.name, got, tt.kinds)
+		}
+		tdb.Close()
+	}
+}
+
+// TestNoDataVerify checks the runs reject verify without data before any query.
+func TestNoDataVerify(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	opts := []Option{WithDB(db, "db"), WithLogger(xlog.NewXLog(ioutil.Discard)), WithStorage(common.NewLocalStorage("unused")), WithNoData(true), WithVerify(true)}
+	if _, err := Dump(context.Background(), opts...); err == nil {
+		t.Error("Dump with no data and verify: want error")
+	}
+	if _, err := Load(context.Background(), opts...); err == nil {
+		t.Error("Load with no data and verify: want error")
+	}
+	if _, err := Copy(context.Background(), db, opts...); err == nil {
+		t.Error("Copy with no data and verify: want error")
+	}
+	if stmts := f.statements(); len(stmts) != 0 {
+		t.Errorf("statements = %q, want none", stmts)
+	}
+}
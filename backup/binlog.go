@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// binlogDir is the directory of the binlog archive in the dump directory.
+const binlogDir = "binlog"
+
+var (
+	untilDatetime = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}$`)
+	untilGTID     = regexp.MustCompile(`^([0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}):(\d+)$`)
+)
+
+// MySQLClient holds the connection of the mysql command line tools.
+type MySQLClient struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	// Socket is used by the tools if Host is "" or localhost.
+	Socket string
+	// Args are the other connection options, like '--ssl-mode=REQUIRED'.
+	Args []string
+}
+
+// command returns the tool with the connection options, the password is passed by env.
+func (c *MySQLClient) command(name string, args ...string) *exec.Cmd {
+	options := []string{"--host=" + c.Host, fmt.Sprintf("--port=%d", c.Port), "--user=" + c.User}
+	if c.Socket != "" {
+		options = append(options, "--socket="+c.Socket)
+	}
+	options = append(options, c.Args...)
+	cmd := exec.Command(name, append(options, args...)...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+c.Password)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// binlogPosition returns the current binlog coordinates of the server, nil if the binlog is off
+// or not in ROW format: the position is read before the tables, so the replay starts at events
+// already in the dump, only the ROW events are idempotent to replay again.
+func binlogPosition(log *xlog.Log, engine *sqlEngine) *common.BinlogPosition {
+	qr, err := engine.QueryString("SELECT @@GLOBAL.binlog_format AS format")
+	if err != nil {
+		log.Warning("dumping.binlog.format.error:%+v", err)
+		return nil
+	}
+	if len(qr) == 0 || !strings.EqualFold(qr[0]["format"], "ROW") {
+		format := ""
+		if len(qr) > 0 {
+			format = qr[0]["format"]
+		}
+		log.Warning("dumping.binlog.format[%s].is.not.ROW, the dump can't be recovered to a point in time", format)
+		return nil
+	}
+	qr, err = engine.QueryString("SHOW MASTER STATUS")
+	if err != nil {
+		// renamed in 8.4
+		qr, err = engine.QueryString("SHOW BINARY LOG STATUS")
+	}
+	if err != nil {
+		log.Warning("dumping.binlog.position.error:%+v", err)
+		return nil
+	}
+	if len(qr) == 0 {
+		log.Warning("dumping.binlog.is.off, the dump can't be recovered to a point in time")
+		return nil
+	}
+	pos, _ := strconv.ParseUint(qr[0]["Position"], 10, 64)
+	p := &common.BinlogPosition{
+		File:     qr[0]["File"],
+		Position: pos,
+		GTIDSet:  strings.Replace(qr[0]["Executed_Gtid_Set"], "\n", "", -1),
+	}
+	log.Info("dumping.binlog[%s:%d].gtid[%s]", p.File, p.Position, p.GTIDSet)
+	return p
+}
+
+// archivedBinlogs returns the sorted binlog files of the archive, starting at from.
+func archivedBinlogs(dir string, from string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(dir, binlogDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		if !info.IsDir() && info.Name() >= from {
+			files = append(files, info.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// ArchiveBinlog used to archive the binlog of the server into the dump directory with mysqlbinlog
+// as a replication client, starting at the position of the dump, or at the last archived file
+// which is fetched again whole. It keeps running until mysqlbinlog fails 5 times in a row.
+func ArchiveBinlog(log *xlog.Log, client *MySQLClient, dir string, start *common.BinlogPosition, serverID int) error {
+	out := filepath.Join(dir, binlogDir)
+	if err := os.MkdirAll(out, 0777); err != nil {
+		return err
+	}
+
+	failures := 0
+	for {
+		file := start.File
+		files, err := archivedBinlogs(dir, start.File)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			file = files[len(files)-1]
+		}
+
+		log.Info("binlog.archive.from[%s].to[%s]", file, out)
+		args := []string{"--read-from-remote-server", "--raw", "--stop-never", "--result-file=" + out + string(os.PathSeparator)}
+		if serverID > 0 {
+			args = append(args, fmt.Sprintf("--connection-server-id=%d", serverID))
+		}
+		t := time.Now()
+		err = client.command("mysqlbinlog", append(args, file)...).Run()
+		if err == nil {
+			log.Info("binlog.archive.stopped")
+			return nil
+		}
+		if time.Since(t) > time.Minute {
+			failures = 0
+		}
+		failures++
+		if failures >= 5 {
+			return fmt.Errorf("mysqlbinlog: %v", err)
+		}
+		wait := time.Duration(failures) * 5 * time.Second
+		log.Warning("binlog.archive.error:%v, retry in %v", err, wait)
+		time.Sleep(wait)
+	}
+}
+
+// ValidUntil returns whether until is a datetime 'YYYY-MM-DD HH:MM:SS' or a GTID 'uuid:N'.
+func ValidUntil(until string) bool {
+	return untilDatetime.MatchString(until) || untilGTID.MatchString(until)
+}
+
+// replayArgs returns the mysqlbinlog arguments replaying the archived files from the position of the dump up to until.
+func replayArgs(dir string, files []string, manifest *common.Manifest, until string, target string, onlyDb bool) []string {
+	// idempotent, as the rows changed while dumping may be in the dump already. The GTIDs are left out,
+	// the server would skip the transactions it has executed already, e.g. restoring to another database
+	// of the same server, so the events are applied as new transactions.
+	args := []string{"--idempotent", "--skip-gtids", fmt.Sprintf("--start-position=%d", manifest.Binlog.Position)}
+	if m := untilGTID.FindStringSubmatch(until); m != nil {
+		// the transactions of the server after the GTID, the ones of the other servers are kept.
+		if n, err := strconv.ParseInt(m[2], 10, 64); err == nil && n < math.MaxInt64 {
+			args = append(args, fmt.Sprintf("--exclude-gtids=%s:%d-%d", m[1], n+1, int64(math.MaxInt64)))
+		}
+	} else if until != "" {
+		args = append(args, "--stop-datetime="+until)
+	}
+	if target == "" {
+		target = manifest.Database
+	}
+	if target != manifest.Database {
+		args = append(args, fmt.Sprintf("--rewrite-db=%s->%s", manifest.Database, target))
+	}
+	// --database filters on the rewritten name.
+	if onlyDb {
+		args = append(args, "--database="+target)
+	}
+	for _, f := range files {
+		args = append(args, filepath.Join(dir, binlogDir, f))
+	}
+	return args
+}
+
+// ReplayBinlog used to apply the archived binlog of the dump up to until, a datetime or a GTID 'uuid:N',
+// "" replays all. The events of the dumped database go to target on db, onlyDb leaves out the other databases.
+func ReplayBinlog(ctx context.Context, log *xlog.Log, db *sql.DB, dir string, manifest *common.Manifest, until string, target string, onlyDb bool) error {
+	if manifest.Binlog == nil {
+		return fmt.Errorf("the dump %s has no binlog position", dir)
+	}
+	files, err := archivedBinlogs(dir, manifest.Binlog.File)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 || files[0] != manifest.Binlog.File {
+		return fmt.Errorf("binlog %s not found in %s", manifest.Binlog.File, filepath.Join(dir, binlogDir))
+	}
+
+	args := replayArgs(dir, files, manifest, until, target, onlyDb)
+	log.Info("binlog.replay.files[%d].from[%s:%d].until[%s]", len(files), manifest.Binlog.File, manifest.Binlog.Position, until)
+	cmd := exec.Command("mysqlbinlog", args...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("mysqlbinlog: %v", err)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	t := time.Now()
+	reader := common.NewStatementReader(stdout)
+	var n int
+	for {
+		stmt, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = cmd.Process.Kill()
+			return err
+		}
+		start := time.Now()
+		_, err = conn.ExecContext(ctx, stmt)
+		observeQuery("restoring", start, err)
+		if err != nil {
+			_ = cmd.Process.Kill()
+			return fmt.Errorf("replay %.80s: %v", stmt, err)
+		}
+		n++
+		if n%10000 == 0 {
+			log.Info("binlog.replay.statements[%d].bytes[%.2fMB]", n, common.MB(reader.BytesRead()))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("mysqlbinlog: %v", err)
+	}
+	log.Info("binlog.replay.done.statements[%d].cost[%s]", n, time.Since(t))
+	return nil
+}
@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"database/sql/driver"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestValidUntil(t *testing.T) {
+	tests := []struct {
+		until string
+		ok    bool
+	}{
+		{"2026-10-01 12:00:00", true},
+		{"2026-10-01T12:00:00", true},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562:23", true},
+		{"", false},
+		{"2026-10-01", false},
+		{"2026-10-01 12:00", false},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562:1-23", false},
+		{"3E11FA47-71CA-11E1-9E33-C80AA9429562", false},
+		{"now; DROP DATABASE test", false},
+	}
+	for _, tt := range tests {
+		if got := ValidUntil(tt.until); got != tt.ok {
+			t.Errorf("ValidUntil(%q) = %v, want %v", tt.until, got, tt.ok)
+		}
+	}
+}
+
+func TestArchivedBinlogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "binlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if files, err := archivedBinlogs(dir, "binlog.000001"); err != nil || len(files) != 0 {
+		t.Errorf("archivedBinlogs without an archive = %v, %v", files, err)
+	}
+	for _, name := range []string{"binlog.000003", "binlog.000001", "binlog.000002", "binlog.000010", "sub/binlog.000004"} {
+		file := filepath.Join(dir, binlogDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := archivedBinlogs(dir, "binlog.000002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"binlog.000002", "binlog.000003", "binlog.000010"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("archivedBinlogs = %v, want %v", files, want)
+	}
+}
+
+func TestReplayArgs(t *testing.T) {
+	manifest := &common.Manifest{Database: "shop", Binlog: &common.BinlogPosition{File: "binlog.000002", Position: 157}}
+	files := []string{"binlog.000002", "binlog.000003"}
+	archive := []string{filepath.Join("/dump", binlogDir, "binlog.000002"), filepath.Join("/dump", binlogDir, "binlog.000003")}
+	tests := []struct {
+		name   string
+		until  string
+		target string
+		onlyDb bool
+		want   []string
+	}{
+		{"all", "", "", false, []string{"--idempotent", "--skip-gtids", "--start-position=157"}},
+		{"datetime", "2026-10-01 12:00:00", "shop", false, []string{"--idempotent", "--skip-gtids", "--start-position=157", "--stop-datetime=2026-10-01 12:00:00"}},
+		{
+			"gtid to another database",
+			"3e11fa47-71ca-11e1-9e33-c80aa9429562:23", "shop_restored", true,
+			[]string{"--idempotent", "--skip-gtids", "--start-position=157", "--exclude-gtids=3e11fa47-71ca-11e1-9e33-c80aa9429562:24-9223372036854775807", "--rewrite-db=shop->shop_restored", "--database=shop_restored"},
+		},
+		{"last gtid", "3e11fa47-71ca-11e1-9e33-c80aa9429562:9223372036854775807", "", false, []string{"--idempotent", "--skip-gtids", "--start-position=157"}},
+	}
+	for _, tt := range tests {
+		got := replayArgs("/dump", files, manifest, tt.until, tt.target, tt.onlyDb)
+		if want := append(tt.want, archive...); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestBinlogPosition(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	engine := &sqlEngine{db: db}
+	log := xlog.NewXLog(ioutil.Discard)
+	f.answer("SHOW MASTER STATUS", []string{"File", "Position", "Executed_Gtid_Set"}, []driver.Value{[]byte("binlog.000003"), []byte("157"), []byte("uuid:1-5,\nother:1-2")})
+
+	f.answer("SELECT @@GLOBAL.binlog_format", []string{"format"}, []driver.Value{[]byte("ROW")})
+	want := &common.BinlogPosition{File: "binlog.000003", Position: 157, GTIDSet: "uuid:1-5,other:1-2"}
+	if got := binlogPosition(log, engine); !reflect.DeepEqual(got, want) {
+		t.Errorf("binlogPosition = %+v, want %+v", got, want)
+	}
+	for _, format := range []string{"STATEMENT", "MIXED"} {
+		f.answer("SELECT @@GLOBAL.binlog_format", []string{"format"}, []driver.Value{[]byte(format)})
+		if got := binlogPosition(log, engine); got != nil {
+			t.Errorf("binlogPosition with the %s format = %+v, want nil", format, got)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// copyChunk is one data chunk of a table on its way to the target.
+type copyChunk struct {
+	table string
+	rows  uint64
+	stmts []string
+	// loaded is done once the chunk is loaded or dropped.
+	loaded *sync.WaitGroup
+}
+
+// copySchema used to create the object on the target, dropping the old one first.
+// The schema is renamed like a restore: the source database becomes the target one.
+func copySchema(log *xlog.Log, args *common.Args, c *sessionConn, kind string, name string, schema string) {
+	if kind == "table" || kind == "view" {
+		_, name = args.Renames.Table(args.SourceDatabase, name)
+	}
+	schema = args.Renames.Rewrite(schema, args.SourceDatabase)
+	for _, query := range []string{fmt.Sprintf("DROP %s IF EXISTS `%s`", strings.ToUpper(kind), name), schema} {
+		err := retryWrite(log, args, "restoring", fmt.Sprintf("copying.%s[%s]", kind, name), func() error {
+			return c.exec(args.Context, c.sess.version(), func(conn *sql.Conn) error {
+				start := time.Now()
+				_, err := conn.ExecContext(args.Context, query)
+				observeQuery("restoring", start, err)
+				return err
+			})
+		})
+		if err != nil {
+			log.Panic("copying.%s[%s].error:%+v", kind, name, err)
+		}
+	}
+	log.Info("copying.schema.%s[%s]", kind, name)
+}
+
+// copyDatabase used to copy the database from the source engine to the target engine without
+// intermediate files, Threads tables are read at once and their chunks are loaded by Threads
+// workers on the target through a bounded channel.
+func copyDatabase(log *xlog.Log, args *common.Args, engine *sqlEngine, target *sqlEngine, f *failure) {
+	t := time.Now()
+	ctx := args.Context
+
+	tables, err := engine.DBMetas()
+	common.AssertNil(err)
+	progress := newProgress(log, args, "copying")
+	progress.estimateDump(engine)
+	// the statements go to the target.
+	capStmtSize(log, args, target, "copying")
+
+	// every connection to the target replays the session after a reconnect.
+	sess := &session{}
+	sess.add("SET FOREIGN_KEY_CHECKS=0")
+	conn := &sessionConn{engine: target, sess: sess}
+	defer conn.close()
+	if !args.NoSchema {
+		for _, table := range tables {
+			copySchema(log, args, conn, "table", table.Name, showCreate(engine, args, "table", table.Name))
+		}
+	}
+
+	// loaders on the target, a worker drains the chunks after a failure so the dumpers waiting for
+	// their tables go on.
+	chunks := make(chan *copyChunk, args.Threads)
+	var loaders sync.WaitGroup
+	for i := 0; i < args.Threads; i++ {
+		loaders.Add(1)
+		go func() {
+			defer loaders.Done()
+			wconn := &sessionConn{engine: target, sess: sess}
+			defer wconn.close()
+			for c := range chunks {
+				func() {
+					defer c.loaded.Done()
+					defer f.catch()
+					checkContext(args)
+					metricWorkers.Inc("restoring")
+					defer metricWorkers.Dec("restoring")
+					err := retryWrite(log, args, "restoring", fmt.Sprintf("copying.table[%s]", c.table), func() error {
+						return wconn.exec(ctx, sess.version(), func(conn *sql.Conn) error {
+							start := time.Now()
+							err := execBatch(ctx, conn, c.stmts)
+							observeQuery("restoring", start, err)
+							return err
+						})
+					})
+					if err != nil {
+						log.Panic("copying.table[%s].error:%+v", c.table, err)
+					}
+					metricChunks.Inc("restoring")
+				}()
+			}
+		}()
+	}
+
+	// dumpers on the source, the after_table hooks run once the chunks of the table are loaded.
+	var dumpers sync.WaitGroup
+	sem := make(chan struct{}, args.Threads)
+	progress.Start()
+	for _, table := range tables {
+		// excludeTable can't copy data
+		if !dumpData(args, table.Name) {
+			progress.setStatus(table.Name, statusSkipped)
+			continue
+		}
+		if args.Context.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		dumpers.Add(1)
+		go func(table *core.Table) {
+			metricWorkers.Inc("dumping")
+			defer func() {
+				metricWorkers.Dec("dumping")
+				<-sem
+				dumpers.Done()
+			}()
+			defer f.catch()
+			log.Info("copying.table[%s.%s].datas...", args.Database, table.Name)
+			fireTableHooks(log, args, engine, target, progress, common.HookBeforeTable, table.Name)
+			progress.setStatus(table.Name, statusRunning)
+			var loaded sync.WaitGroup
+			dumpTable(log, engine, args, progress, table, func(fileNo int, rows uint64, data string) {
+				data = strings.TrimSuffix(data, ";\n")
+				if data == "" {
+					return
+				}
+				stmts := strings.Split(data, ";\n")
+				if !args.Renames.Empty() {
+					for i, stmt := range stmts {
+						stmts[i] = args.Renames.Rewrite(stmt, args.SourceDatabase)
+					}
+				}
+				loaded.Add(1)
+				select {
+				case chunks <- &copyChunk{table: table.Name, rows: rows, stmts: stmts, loaded: &loaded}:
+				case <-args.Context.Done():
+					loaded.Done()
+					checkContext(args)
+				}
+			})
+			loaded.Wait()
+			checkContext(args)
+			progress.setStatus(table.Name, statusDone)
+			fireTableHooks(log, args, engine, target, progress, common.HookAfterTable, table.Name)
+		}(table)
+	}
+	dumpers.Wait()
+	close(chunks)
+	loaders.Wait()
+	f.check()
+
+	for _, routineType := range routineTypes(args) {
+		kind := strings.ToLower(routineType)
+		for _, name := range listRoutines(engine, args, routineType) {
+			copySchema(log, args, conn, kind, name, showCreate(engine, args, kind, name))
+		}
+	}
+	if !args.NoViews {
+		for _, name := range listViews(engine, args) {
+			copySchema(log, args, conn, "view", name, showCreate(engine, args, "view", name))
+		}
+	}
+
+	progress.Stop()
+	elapsedStr, elapsed := time.Since(t).String(), time.Since(t).Seconds()
+	log.Info("copying.all.done.cost[%s].allrows[%v].allbytes[%v].rate[%.2fMB/s]", elapsedStr, args.Allrows, args.Allbytes, common.MB(args.Allbytes)/elapsed)
+}
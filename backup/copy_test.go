@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// copyStatements returns the statements the copy of the fake source db ran on the target db2.
+func copyStatements(t *testing.T, opts ...Option) []string {
+	source, sdb := newFakeDB(t)
+	defer sdb.Close()
+	source.answerSource()
+	target, tdb := newFakeDB(t)
+	defer tdb.Close()
+	target.answer("SELECT IFNULL(DATABASE(), '')", []string{"db"}, []driver.Value{[]byte("db2")})
+	target.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+
+	opts = append([]Option{WithDB(sdb, "db"), WithLogger(xlog.NewXLog(ioutil.Discard)), WithThreads(2)}, opts...)
+	if _, err := Copy(context.Background(), tdb, opts...); err != nil {
+		t.Fatal(err)
+	}
+	var stmts []string
+	for _, s := range target.statements() {
+		if s = s[3:]; !strings.HasPrefix(s, "SELECT ") {
+			stmts = append(stmts, s)
+		}
+	}
+	return stmts
+}
+
+// TestCopyRename checks the schema, the rows and the routines reach the target with the names rewritten.
+func TestCopyRename(t *testing.T) {
+	renames, err := common.ParseRenames("db.t1=t1_copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := copyStatements(t, WithRenames(renames))
+	want := []string{
+		"DROP TABLE IF EXISTS `t1_copy`",
+		"CREATE TABLE `t1_copy` (\n  `id` int NOT NULL,\n  `name` varchar(10),\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB",
+		"INSERT INTO `t1_copy`(`id`, `name`) VALUES\n(1, 'a'),\n(2, 'b')",
+		"DROP FUNCTION IF EXISTS `f1`",
+		"CREATE DEFINER=`root`@`%` FUNCTION `f1`() RETURNS int\n    DETERMINISTIC\nRETURN (SELECT COUNT(*) FROM `db2`.`t1_copy`)",
+		"DROP PROCEDURE IF EXISTS `p1`",
+		"CREATE DEFINER=`root`@`%` PROCEDURE `p1`()\nBEGIN\n  DELETE FROM `db2`.`t1_copy` WHERE id = 0;\nEND",
+		"DROP VIEW IF EXISTS `v1`",
+		"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v1` AS select `db2`.`t1_copy`.`id` AS `id` from `db2`.`t1_copy`",
+	}
+	index := map[string]int{}
+	for i, s := range stmts {
+		index[s] = i
+		if strings.Contains(s, "`db`") || strings.Contains(s, "`t1`") {
+			t.Errorf("statement %q names the source", s)
+		}
+	}
+	for i, s := range want {
+		if _, ok := index[s]; !ok {
+			t.Fatalf("statement %q didn't run: %q", s, stmts)
+		}
+		if i > 0 && index[s] < index[want[i-1]] {
+			t.Errorf("statement %q ran before %q", s, want[i-1])
+		}
+	}
+}
+
+func TestCopySkip(t *testing.T) {
+	for _, tt := range skipTests {
+		if got := statementKinds(copyStatements(t, tt.opts...)); got != tt.kinds {
+			t.Errorf("%s: copied %s, want %s", tt.name, got, tt.kinds)
+		}
+	}
+}
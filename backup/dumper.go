@@ -0,0 +1,412 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// writeFile used to write the dump file and record it in the manifest, the manifest has the
+// checksum of the stored bytes, so the files can be verified without decrypting them.
+func writeFile(args *common.Args, name string, data string, entry common.ManifestFile) {
+	raw := common.StringToBytes(data)
+	if args.Cipher != nil {
+		var err error
+		raw, err = args.Cipher.Seal(raw)
+		common.AssertNil(err)
+	}
+	err := args.Storage.Create(name, raw)
+	common.AssertNil(err)
+	entry.Name = name
+	args.Manifest.Add(entry, raw)
+}
+
+func writeDBName(args *common.Args) {
+	writeFile(args, "dbname", args.Database, common.ManifestFile{Kind: "dbname"})
+}
+
+func listViews(engine *sqlEngine, args *common.Args) []string {
+	qr, err := engine.QueryString(fmt.Sprintf("SHOW TABLE STATUS FROM `%s` WHERE Comment='view';", args.Database))
+	common.AssertNil(err)
+
+	views := make([]string, 0, len(qr))
+	for _, t := range qr {
+		views = append(views, t["Name"])
+	}
+	return views
+}
+
+// routineTypes returns the types of the routines to dump, none with NoRoutines.
+func routineTypes(args *common.Args) []string {
+	if args.NoRoutines {
+		return nil
+	}
+	return []string{"FUNCTION", "PROCEDURE"}
+}
+
+func listRoutines(engine *sqlEngine, args *common.Args, routineType string) []string {
+	qr, err := engine.QueryString(fmt.Sprintf("SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_TYPE = '%s' AND ROUTINE_SCHEMA = '%s'", routineType, args.Database))
+	common.AssertNil(err)
+
+	routines := make([]string, 0, len(qr))
+	for _, t := range qr {
+		routines = append(routines, t["ROUTINE_NAME"])
+	}
+	return routines
+}
+
+// showCreate returns the definition of the table, view, function or procedure.
+func showCreate(engine *sqlEngine, args *common.Args, kind string, name string) string {
+	start := time.Now()
+	query := fmt.Sprintf("SHOW CREATE %s `%s`.`%s`", strings.ToUpper(kind), args.Database, name)
+	if kind == "view" {
+		query = fmt.Sprintf("SHOW CREATE TABLE `%s`.`%s`", args.Database, name)
+	}
+	qr, err := engine.QueryString(query)
+	observeQuery("dumping", start, err)
+	common.AssertNil(err)
+	return qr[0]["Create "+strings.Title(kind)]
+}
+
+func dumpViewSchema(log *xlog.Log, engine *sqlEngine, args *common.Args) {
+	for _, viewName := range listViews(engine, args) {
+		schema := showCreate(engine, args, "view", viewName) + ";\n"
+		file := fmt.Sprintf("%s-view.sql", viewName)
+		writeFile(args, file, schema, common.ManifestFile{Kind: "view", Table: viewName})
+		log.Info("dumping.view[%s.%s].schema...", args.Database, viewName)
+	}
+}
+
+func dumpRoutineSchema(log *xlog.Log, engine *sqlEngine, args *common.Args, routineType string) {
+	kind := strings.ToLower(routineType)
+	for _, routineName := range listRoutines(engine, args, routineType) {
+		schema := showCreate(engine, args, kind, routineName) + ";\n"
+		file := fmt.Sprintf("%s-%s.sql", routineName, kind)
+		writeFile(args, file, schema, common.ManifestFile{Kind: kind, Table: routineName})
+		log.Info("dumping.routine[%s.%s].schema...", args.Database, routineName)
+	}
+}
+
+func dumpTableSchema(log *xlog.Log, engine *sqlEngine, args *common.Args, tableName string) {
+	file := fmt.Sprintf("%s-table.sql", tableName)
+	writeFile(args, file, showCreate(engine, args, "table", tableName)+";\n", common.ManifestFile{Kind: "table", Table: tableName})
+	log.Info("dumping.table[%s.%s].schema...", args.Database, tableName)
+}
+
+// dumpData returns whether the rows of the table are dumped.
+func dumpData(args *common.Args, table string) bool {
+	return !args.NoData && !strings.Contains(args.ExcludeTables, table)
+}
+
+// writeChunk used to write the data chunk of the table as a file of the dump directory.
+func writeChunk(args *common.Args, table string, fileNo int, rows uint64, data string) {
+	file := fmt.Sprintf("%s.%05d.%s", table, fileNo, args.Format)
+	writeFile(args, file, data, common.ManifestFile{Kind: "data", Table: table, Chunk: fileNo, Rows: rows})
+}
+
+// dumpTable used to dump the rows of the table, every chunk is passed to emit.
+// A transient error reads the table again from the last emitted chunk: an InnoDB table with a
+// primary key is scanned in its order and resumes after the last key, the others are read again
+// only if no chunk was emitted.
+// With args.Verify it returns the checksum of the table, taken in the repeatable read transaction
+// the rows are read in, so both see the same snapshot of an InnoDB table. A new snapshot can't
+// resume the emitted chunks, the table is read again only if no chunk was emitted.
+func dumpTable(log *xlog.Log, engine *sqlEngine, args *common.Args, progress *Progress, table *core.Table, emit func(fileNo int, rows uint64, data string)) *common.TableChecksum {
+	var allBytes uint64
+	var allRows uint64
+
+	query := fmt.Sprintf("SELECT /*backup*/ * FROM `%s`.`%s`", args.Database, table.Name)
+	wheres := []string{""}
+	if f, ok := args.Filters[table.Name]; ok {
+		wheres = f.Where
+	}
+
+	cols := table.ColumnsSeq()
+	// keys are the indexes of the primary key columns in the rows, nil if the scan can't resume.
+	var keys []int
+	if strings.EqualFold(table.StoreEngine, "InnoDB") {
+		for _, pk := range table.PrimaryKeys {
+			for i, col := range cols {
+				if col == pk {
+					keys = append(keys, i)
+				}
+			}
+		}
+		if len(keys) != len(table.PrimaryKeys) {
+			keys = nil
+		}
+	}
+	enc := newEncoder(args, engine.Dialect(), table)
+	m := newMasker(engine, args, table)
+	if m != nil {
+		enc = &maskEncoder{encoder: enc, masker: m}
+		log.Info("dumping.table[%s.%s].masked.columns[%d]", args.Database, table.Name, len(m.columns))
+	}
+	// a statement is kept below max_allowed_packet, but a row beyond it can't be split.
+	limit := 0
+	if args.Format == formatSQL {
+		limit = stmtLimit(args)
+	}
+	overhead := len(enc.statement(nil))
+
+	fileNo := 1
+	stmtsize := 0
+	chunkbytes := 0
+	var chunkRows uint64
+	rows := make([]string, 0, 256)
+	inserts := make([]string, 0, 256)
+	// mark is the state at the last emitted chunk, the scan resumes from it.
+	var mark struct {
+		where       int
+		key         []interface{}
+		rows, bytes uint64
+		oversized   uint64
+	}
+	var oversized uint64
+	var checksum *common.TableChecksum
+	scan := func() error {
+		reader := queryer(engine.DB())
+		if args.Verify {
+			tx, err := engine.DB().BeginTx(args.Context, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+			// the first read of the transaction takes the snapshot.
+			if checksum, err = tableChecksum(tx, args.Database, table.Name); err != nil {
+				return err
+			}
+			reader = tx
+		}
+
+		// drop the rows read beyond the mark.
+		progress.undo(table.Name, allBytes-mark.bytes, allRows-mark.rows)
+		allRows, allBytes, oversized = mark.rows, mark.bytes, mark.oversized
+		if m != nil {
+			m.rewind(mark.rows)
+		}
+		rows, inserts = rows[:0], inserts[:0]
+		stmtsize, chunkbytes, chunkRows = 0, 0, 0
+
+		// last is the key of the last row read, flushed of the last row in the statements.
+		var last, flushed []interface{}
+		flush := func() {
+			checkContext(args)
+			inserts = append(inserts, enc.statement(rows))
+			chunkRows += uint64(len(rows))
+			rows = rows[:0]
+			stmtsize = 0
+			flushed = last
+		}
+
+		for w := mark.where; w < len(wheres); w++ {
+			where := wheres[w]
+			resumable := keys != nil && where == ""
+			var params []interface{}
+			q := query
+			switch {
+			case where != "":
+				q += " WHERE " + where
+			case resumable && mark.key != nil:
+				q += fmt.Sprintf(" WHERE (%s) > (%s)", quoteColumns(table.PrimaryKeys), strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", "))
+				params = mark.key
+			}
+			if resumable {
+				q += " ORDER BY " + quoteColumns(table.PrimaryKeys)
+			}
+
+			last, flushed = nil, nil
+			start := time.Now()
+			cursor, err := reader.Query(q, params...)
+			observeQuery("dumping", start, err)
+			if err != nil {
+				return err
+			}
+			defer cursor.Close()
+
+			for cursor.Next() {
+				dest := make([]interface{}, len(cols))
+				ptrs := make([]interface{}, len(cols))
+				for i := range dest {
+					ptrs[i] = &dest[i]
+				}
+				if err := cursor.Scan(ptrs...); err != nil {
+					return err
+				}
+
+				r := enc.row(dest)
+				if limit > 0 {
+					if len(rows) > 0 && overhead+stmtsize+2*len(rows)+len(r) > limit {
+						flush()
+					}
+					if overhead+len(r) > limit {
+						oversized++
+					}
+				}
+				rows = append(rows, r)
+				if resumable {
+					last = make([]interface{}, len(keys))
+					for i, k := range keys {
+						last[i] = dest[k]
+					}
+				}
+
+				allRows++
+				stmtsize += len(r)
+				chunkbytes += len(r)
+				allBytes += uint64(len(r))
+				progress.add(table.Name, uint64(len(r)), 1)
+
+				if stmtsize >= args.StmtSize {
+					flush()
+				}
+
+				if (chunkbytes / 1024 / 1024) >= args.ChunksizeInMB {
+					emit(fileNo, chunkRows, enc.chunk(inserts))
+					metricChunks.Inc("dumping")
+
+					log.Info("dumping.table[%s.%s].rows[%v].bytes[%.2fMB].part[%v]", args.Database, table.Name, allRows, common.MB(allBytes), fileNo)
+					inserts = inserts[:0]
+					chunkbytes = 0
+					chunkRows = 0
+					fileNo++
+
+					// the rows left out of the statements go to the next chunk, they are read again.
+					mark.where, mark.key = w, flushed
+					mark.rows, mark.bytes = allRows-uint64(len(rows)), allBytes-uint64(stmtsize)
+					mark.oversized = oversized
+				}
+			}
+			if err := cursor.Err(); err != nil {
+				return err
+			}
+			if err := cursor.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := retry(log, args, "dumping", fmt.Sprintf("dumping.table[%s.%s]", args.Database, table.Name), func() error {
+		err := scan()
+		if err != nil && retryable(err) && fileNo > 1 && (mark.key == nil || args.Verify) {
+			// the rows after the emitted chunks can't be told apart, don't retry.
+			return fmt.Errorf("%v, can't resume the table after the emitted chunks", err)
+		}
+		return err
+	})
+	common.AssertNil(err)
+	if chunkbytes > 0 {
+		if len(rows) > 0 {
+			inserts = append(inserts, enc.statement(rows))
+			chunkRows += uint64(len(rows))
+		}
+
+		emit(fileNo, chunkRows, enc.chunk(inserts))
+		metricChunks.Inc("dumping")
+	}
+	if oversized > 0 {
+		log.Warning("dumping.table[%s.%s].rows[%d].exceed.max_allowed_packet[%d], they can't be loaded unless it's raised", args.Database, table.Name, oversized, args.MaxAllowedPacket)
+	}
+
+	log.Info("dumping.table[%s.%s].done.allrows[%v].allbytes[%.2fMB]...", args.Database, table.Name, allRows, common.MB(allBytes))
+	return checksum
+}
+
+// dumpDir used to dump the database to the storage, the manifest is written last.
+func dumpDir(log *xlog.Log, args *common.Args, engine *sqlEngine, f *failure) {
+
+	var wg sync.WaitGroup
+	t := time.Now()
+
+	tables, err := engine.DBMetas()
+	common.AssertNil(err)
+
+	progress := newProgress(log, args, "dumping")
+	progress.estimateDump(engine)
+	capStmtSize(log, args, engine, "dumping")
+	args.Manifest = common.NewManifest(args.Database)
+	args.Manifest.Watermarks = args.Watermarks
+	args.Manifest.IncrementalFrom = args.IncrementalFrom
+	args.Manifest.Binlog = binlogPosition(log, engine)
+
+	wg.Add(4)
+	//databaseName
+	go func() {
+		defer wg.Done()
+		defer f.catch()
+		writeDBName(args)
+	}()
+	//function
+	go func() {
+		defer wg.Done()
+		defer f.catch()
+		if !args.NoRoutines {
+			dumpRoutineSchema(log, engine, args, "FUNCTION")
+		}
+	}()
+	//procedure
+	go func() {
+		defer wg.Done()
+		defer f.catch()
+		if !args.NoRoutines {
+			dumpRoutineSchema(log, engine, args, "PROCEDURE")
+		}
+	}()
+	//view
+	go func() {
+		defer wg.Done()
+		defer f.catch()
+		if !args.NoViews {
+			dumpViewSchema(log, engine, args)
+		}
+	}()
+
+	for _, table := range tables {
+		if !args.NoSchema {
+			dumpTableSchema(log, engine, args, table.Name)
+		}
+
+		wg.Add(1)
+		go func(engine *sqlEngine, table *core.Table) {
+			metricWorkers.Inc("dumping")
+			defer func() {
+				metricWorkers.Dec("dumping")
+				wg.Done()
+			}()
+			defer f.catch()
+			// excludeTable can't dump data
+			if dumpData(args, table.Name) {
+				log.Info("dumping.table[%s.%s].datas...", args.Database, table.Name)
+				fireTableHooks(log, args, engine, nil, progress, common.HookBeforeTable, table.Name)
+				progress.setStatus(table.Name, statusRunning)
+				c := dumpTable(log, engine, args, progress, table, func(fileNo int, rows uint64, data string) {
+					writeChunk(args, table.Name, fileNo, rows, data)
+				})
+				if c != nil {
+					args.Manifest.SetChecksum(table.Name, c)
+					log.Info("dumping.table[%s.%s].checksum[%s].rows[%v]", args.Database, table.Name, c.Checksum, c.Rows)
+				}
+				progress.setStatus(table.Name, statusDone)
+				fireTableHooks(log, args, engine, nil, progress, common.HookAfterTable, table.Name)
+				log.Info("dumping.table[%s.%s].datas.done...", args.Database, table.Name)
+			} else {
+				progress.setStatus(table.Name, statusSkipped)
+			}
+		}(engine, table)
+	}
+
+	progress.Start()
+	wg.Wait()
+	f.check()
+	progress.Stop()
+	err = args.Manifest.Write(args.Storage, args.Cipher)
+	common.AssertNil(err)
+	elapsedStr, elapsed := time.Since(t).String(), time.Since(t).Seconds()
+	log.Info("dumping.all.done.cost[%s].allrows[%v].allbytes[%v].rate[%.2fMB/s]", elapsedStr, args.Allrows, args.Allbytes, common.MB(args.Allbytes)/elapsed)
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"database/sql"
+	"fmt"
+	// registers the dialects of xorm.
+	_ "github.com/go-xorm/xorm"
+	"xorm.io/core"
+)
+
+// sqlEngine wraps the *sql.DB of the database, the table metas are read by the mysql dialect of xorm.
+type sqlEngine struct {
+	db      *sql.DB
+	dialect core.Dialect
+}
+
+func newEngine(db *sql.DB, database string) (*sqlEngine, error) {
+	dialect := core.QueryDialect(core.MYSQL)
+	if err := dialect.Init(core.FromDB(db), &core.Uri{DbType: core.MYSQL, DbName: database}, "mysql", ""); err != nil {
+		return nil, err
+	}
+	return &sqlEngine{db: db, dialect: dialect}, nil
+}
+
+// DB returns the database.
+func (e *sqlEngine) DB() *sql.DB {
+	return e.db
+}
+
+// Dialect returns the mysql dialect.
+func (e *sqlEngine) Dialect() core.Dialect {
+	return e.dialect
+}
+
+// DBMetas returns the tables of the database with their columns and indexes.
+func (e *sqlEngine) DBMetas() ([]*core.Table, error) {
+	tables, err := e.dialect.GetTables()
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		colSeq, cols, err := e.dialect.GetColumns(table.Name)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range colSeq {
+			table.AddColumn(cols[name])
+		}
+		if table.Indexes, err = e.dialect.GetIndexes(table.Name); err != nil {
+			return nil, err
+		}
+		for _, index := range table.Indexes {
+			for _, name := range index.Cols {
+				col := table.GetColumn(name)
+				if col == nil {
+					return nil, fmt.Errorf("unknown column %s in index %s of table %s", name, index.Name, table.Name)
+				}
+				col.Indexes[index.Name] = index.Type
+			}
+		}
+	}
+	return tables, nil
+}
+
+// QueryString returns the rows of the query as strings, NULL is "".
+func (e *sqlEngine) QueryString(query string) ([]map[string]string, error) {
+	return queryString(e.db, query)
+}
+
+// queryer runs the queries on a *sql.DB, or in the transaction of a *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryString(q queryer, query string) ([]map[string]string, error) {
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []map[string]string
+	values := make([]sql.RawBytes, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]string, len(cols))
+		for i, c := range cols {
+			row[c] = string(values[i])
+		}
+		out = append(out, row)
+	}
+	return out, rows.Err()
+}
+
+// Exec used to run the statement.
+func (e *sqlEngine) Exec(query string) (sql.Result, error) {
+	return e.db.Exec(query)
+}
@@ -0,0 +1,188 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDB is a database/sql driver answering the queries by their prefix, it records the
+// statements it got with the connection they ran on.
+type fakeDB struct {
+	mu      sync.Mutex
+	results map[string]*fakeRows
+	fails   map[string]*fakeRows
+	log     []string
+	conns   int
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	i    int
+	err  error
+}
+
+var (
+	fakeMu  sync.Mutex
+	fakeDBs = map[string]*fakeDB{}
+)
+
+func init() {
+	sql.Register("fake", fakeDriver{})
+}
+
+// newFakeDB returns a new fake database of the test and a *sql.DB connected to it.
+func newFakeDB(t *testing.T) (*fakeDB, *sql.DB) {
+	f := &fakeDB{results: map[string]*fakeRows{}, fails: map[string]*fakeRows{}}
+	fakeMu.Lock()
+	name := fmt.Sprintf("%s#%d", t.Name(), len(fakeDBs))
+	fakeDBs[name] = f
+	fakeMu.Unlock()
+	db, err := sql.Open("fake", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, db
+}
+
+// answer used to set the result of the queries starting with prefix.
+func (f *fakeDB) answer(prefix string, cols []string, rows ...[]driver.Value) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[prefix] = &fakeRows{cols: cols, rows: rows}
+}
+
+// failOnce used to make the next query starting with prefix fail with err after n rows.
+func (f *fakeDB) failOnce(prefix string, n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.fails[prefix] = &fakeRows{rows: make([][]driver.Value, n), err: err}
+}
+
+// answerSource used to answer the queries dumping the database db: the table t1 with two rows,
+// the view v1, the function f1 and the procedure p1, the definitions name the database.
+func (f *fakeDB) answerSource() {
+	f.answer("SELECT `TABLE_NAME`, `ENGINE`", []string{"TABLE_NAME", "ENGINE", "TABLE_ROWS", "AUTO_INCREMENT", "TABLE_COMMENT"},
+		[]driver.Value{[]byte("t1"), []byte("InnoDB"), []byte("2"), nil, []byte("")})
+	f.answer("SELECT `COLUMN_NAME`, `IS_NULLABLE`", []string{"COLUMN_NAME", "IS_NULLABLE", "COLUMN_DEFAULT", "COLUMN_TYPE", "COLUMN_KEY", "EXTRA", "COLUMN_COMMENT"},
+		[]driver.Value{[]byte("id"), []byte("NO"), nil, []byte("int(11)"), []byte("PRI"), []byte(""), []byte("")},
+		[]driver.Value{[]byte("name"), []byte("YES"), nil, []byte("varchar(10)"), []byte(""), []byte(""), []byte("")})
+	f.answer("SELECT `INDEX_NAME`", []string{"INDEX_NAME", "NON_UNIQUE", "COLUMN_NAME"})
+	f.answer("SELECT TABLE_NAME, IFNULL", []string{"TABLE_NAME", "TABLE_ROWS", "DATA_LENGTH"}, []driver.Value{[]byte("t1"), []byte("2"), []byte("16384")})
+	f.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+	f.answer("SELECT @@GLOBAL.binlog_format", []string{"format"}, []driver.Value{[]byte("ROW")})
+	f.answer("SHOW MASTER STATUS", []string{"File", "Position", "Executed_Gtid_Set"})
+	f.answer("SHOW CREATE TABLE `db`.`t1`", []string{"Table", "Create Table"},
+		[]driver.Value{[]byte("t1"), []byte("CREATE TABLE `t1` (\n  `id` int NOT NULL,\n  `name` varchar(10),\n  PRIMARY KEY (`id`)\n) ENGINE=InnoDB")})
+	f.answer("SHOW TABLE STATUS FROM `db`", []string{"Name"}, []driver.Value{[]byte("v1")})
+	f.answer("SHOW CREATE TABLE `db`.`v1`", []string{"View", "Create View"},
+		[]driver.Value{[]byte("v1"), []byte("CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v1` AS select `db`.`t1`.`id` AS `id` from `db`.`t1`")})
+	f.answer("SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_TYPE = 'FUNCTION'", []string{"ROUTINE_NAME"}, []driver.Value{[]byte("f1")})
+	f.answer("SELECT ROUTINE_NAME FROM information_schema.ROUTINES WHERE ROUTINE_TYPE = 'PROCEDURE'", []string{"ROUTINE_NAME"}, []driver.Value{[]byte("p1")})
+	f.answer("SHOW CREATE FUNCTION `db`.`f1`", []string{"Function", "Create Function"},
+		[]driver.Value{[]byte("f1"), []byte("CREATE DEFINER=`root`@`%` FUNCTION `f1`() RETURNS int\n    DETERMINISTIC\nRETURN (SELECT COUNT(*) FROM `db`.`t1`)")})
+	f.answer("SHOW CREATE PROCEDURE `db`.`p1`", []string{"Procedure", "Create Procedure"},
+		[]driver.Value{[]byte("p1"), []byte("CREATE DEFINER=`root`@`%` PROCEDURE `p1`()\nBEGIN\n  DELETE FROM `db`.`t1` WHERE id = 0;\nEND")})
+	f.answer("SELECT /*backup*/ * FROM `db`.`t1`", []string{"id", "name"}, []driver.Value{int64(1), []byte("a")}, []driver.Value{int64(2), []byte("b")})
+}
+
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeMu.Lock()
+	f := fakeDBs[name]
+	fakeMu.Unlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conns++
+	return &fakeConn{db: f, id: f.conns}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+	id int
+}
+
+func (c *fakeConn) record(s string) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.log = append(c.db.log, fmt.Sprintf("%d: %s", c.id, s))
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) { return &fakeStmt{c, query}, nil }
+func (c *fakeConn) Close() error                              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.record("BEGIN")
+	return c, nil
+}
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.record(fmt.Sprintf("BEGIN isolation=%s read_only=%v", sql.IsolationLevel(opts.Isolation), opts.ReadOnly))
+	return c, nil
+}
+func (c *fakeConn) Commit() error {
+	c.record("COMMIT")
+	return nil
+}
+func (c *fakeConn) Rollback() error {
+	c.record("ROLLBACK")
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.c.record(s.query)
+	return driver.RowsAffected(0), nil
+}
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.c.record(s.query)
+	s.c.db.mu.Lock()
+	defer s.c.db.mu.Unlock()
+	// the longest prefix answers.
+	match := ""
+	for prefix := range s.c.db.results {
+		if strings.HasPrefix(s.query, prefix) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	r, ok := s.c.db.results[match]
+	if !ok {
+		return nil, fmt.Errorf("fake: no answer to %q", s.query)
+	}
+	if fail, ok := s.c.db.fails[match]; ok {
+		delete(s.c.db.fails, match)
+		return &fakeRows{cols: r.cols, rows: r.rows[:len(fail.rows)], err: fail.err}, nil
+	}
+	return &fakeRows{cols: r.cols, rows: r.rows}, nil
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
@@ -0,0 +1,293 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"xorm.io/core"
+
+	"mysqldump/common"
+)
+
+// Data formats.
+const (
+	formatSQL   = "sql"
+	formatCSV   = "csv"
+	formatTSV   = "tsv"
+	formatJSONL = "jsonl"
+)
+
+// encoder used to encode the rows of a table into data chunks.
+type encoder interface {
+	// row encodes one row.
+	row(dest []interface{}) string
+	// statement joins the encoded rows into one statement.
+	statement(rows []string) string
+	// chunk joins the statements into the content of a chunk file.
+	chunk(stmts []string) string
+}
+
+func newEncoder(args *common.Args, dialect core.Dialect, table *core.Table) encoder {
+	cols := table.ColumnsSeq()
+	columns := make([]*core.Column, len(cols))
+	for i, c := range cols {
+		columns[i] = table.GetColumn(c)
+	}
+
+	switch args.Format {
+	case formatCSV:
+		return &delimitedEncoder{columns: columns, sep: ",", enclose: true}
+	case formatTSV:
+		return &delimitedEncoder{columns: columns, sep: "\t"}
+	case formatJSONL:
+		return newJSONEncoder(columns)
+	}
+	e := &sqlEncoder{
+		table:        table.Name,
+		columns:      columns,
+		dialect:      dialect,
+		destColNames: dialect.Quote(strings.Join(cols, dialect.Quote(", "))),
+	}
+	// incremental dumps are applied on top of the base with upserts.
+	if args.IncrementalFrom != "" {
+		updates := make([]string, len(cols))
+		for i, c := range cols {
+			updates[i] = fmt.Sprintf("%s=VALUES(%s)", dialect.Quote(c), dialect.Quote(c))
+		}
+		e.upsert = "\nON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+	}
+	return e
+}
+
+// sqlEncoder encodes rows as multi-row INSERT statements.
+type sqlEncoder struct {
+	table        string
+	columns      []*core.Column
+	dialect      core.Dialect
+	destColNames string
+	upsert       string
+}
+
+func (e *sqlEncoder) row(dest []interface{}) string {
+	var temp string
+	for i, d := range dest {
+		col := e.columns[i]
+
+		if d == nil {
+			temp += ", NULL"
+		} else if col.SQLType.IsText() || col.SQLType.IsTime() {
+			var v = fmt.Sprintf("%s", d)
+			if strings.HasSuffix(v, " +0000 UTC") {
+				temp += fmt.Sprintf(", '%s'", v[0:len(v)-len(" +0000 UTC")])
+			} else {
+				temp += ", '" + common.EscapeString(v) + "'"
+			}
+		} else if col.SQLType.IsBlob() {
+			if reflect.TypeOf(d).Kind() == reflect.Slice {
+				temp += fmt.Sprintf(", %s", e.dialect.FormatBytes(d.([]byte)))
+			} else if reflect.TypeOf(d).Kind() == reflect.String {
+				temp += fmt.Sprintf(", '%s'", d.(string))
+			}
+		} else if col.SQLType.IsNumeric() {
+			switch reflect.TypeOf(d).Kind() {
+			case reflect.Slice:
+				if col.SQLType.Name == core.Bool {
+					temp += fmt.Sprintf(", %v", strconv.FormatBool(d.([]byte)[0] != byte('0')))
+				} else {
+					temp += fmt.Sprintf(", %s", string(d.([]byte)))
+				}
+			case reflect.Int16, reflect.Int8, reflect.Int32, reflect.Int64, reflect.Int:
+				if col.SQLType.Name == core.Bool {
+					temp += fmt.Sprintf(", %v", strconv.FormatBool(reflect.ValueOf(d).Int() > 0))
+				} else {
+					temp += fmt.Sprintf(", %v", d)
+				}
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				if col.SQLType.Name == core.Bool {
+					temp += fmt.Sprintf(", %v", strconv.FormatBool(reflect.ValueOf(d).Uint() > 0))
+				} else {
+					temp += fmt.Sprintf(", %v", d)
+				}
+			default:
+				temp += fmt.Sprintf(", %v", d)
+			}
+		} else {
+			s := fmt.Sprintf("%v", d)
+			if strings.Contains(s, ":") || strings.Contains(s, "-") {
+				if strings.HasSuffix(s, " +0000 UTC") {
+					temp += fmt.Sprintf(", '%s'", s[0:len(s)-len(" +0000 UTC")])
+				} else {
+					temp += fmt.Sprintf(", '%s'", s)
+				}
+			} else {
+				temp += fmt.Sprintf(", %s", s)
+			}
+		}
+	}
+	return "(" + temp[2:] + ")"
+}
+
+func (e *sqlEncoder) statement(rows []string) string {
+	return fmt.Sprintf("INSERT INTO `%s`(%s) VALUES\n%s%s", e.table, e.destColNames, strings.Join(rows, ",\n"), e.upsert)
+}
+
+func (e *sqlEncoder) chunk(stmts []string) string {
+	return strings.Join(stmts, ";\n") + ";\n"
+}
+
+// delimitedEncoder encodes rows as csv or tsv lines which LOAD DATA can read with
+// ESCAPED BY '\\', NULL is written as \N and binary columns are hex encoded.
+type delimitedEncoder struct {
+	columns []*core.Column
+	sep     string
+	enclose bool
+}
+
+// plainValue returns the value as text, binary values are hex encoded.
+func plainValue(col *core.Column, d interface{}) string {
+	var v string
+	switch x := d.(type) {
+	case []byte:
+		if col.SQLType.IsBlob() {
+			return strings.ToUpper(hex.EncodeToString(x))
+		}
+		v = string(x)
+	case string:
+		if col.SQLType.IsBlob() {
+			return strings.ToUpper(hex.EncodeToString([]byte(x)))
+		}
+		v = x
+	default:
+		v = fmt.Sprintf("%v", d)
+	}
+	return strings.TrimSuffix(v, " +0000 UTC")
+}
+
+func (e *delimitedEncoder) field(v string) string {
+	var b strings.Builder
+	if e.enclose {
+		b.WriteByte('"')
+	}
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\x00':
+			b.WriteString(`\0`)
+		case c == '"' && e.enclose:
+			b.WriteString(`""`)
+		case c == '\t' && !e.enclose:
+			b.WriteString(`\t`)
+		case c == '\n' && !e.enclose:
+			b.WriteString(`\n`)
+		case c == '\r' && !e.enclose:
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	if e.enclose {
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func (e *delimitedEncoder) row(dest []interface{}) string {
+	fields := make([]string, len(dest))
+	for i, d := range dest {
+		if d == nil {
+			fields[i] = `\N`
+		} else {
+			fields[i] = e.field(plainValue(e.columns[i], d))
+		}
+	}
+	return strings.Join(fields, e.sep) + "\n"
+}
+
+func (e *delimitedEncoder) statement(rows []string) string {
+	return strings.Join(rows, "")
+}
+
+// chunk starts with a header line of the column names.
+func (e *delimitedEncoder) chunk(stmts []string) string {
+	names := make([]string, len(e.columns))
+	for i, c := range e.columns {
+		names[i] = e.field(c.Name)
+	}
+	return strings.Join(names, e.sep) + "\n" + strings.Join(stmts, "")
+}
+
+// jsonEncoder encodes rows as json lines keyed by the column names, in the column order.
+type jsonEncoder struct {
+	columns []*core.Column
+	keys    []string
+}
+
+func newJSONEncoder(columns []*core.Column) *jsonEncoder {
+	keys := make([]string, len(columns))
+	for i, c := range columns {
+		key, _ := json.Marshal(c.Name)
+		keys[i] = string(key)
+	}
+	return &jsonEncoder{columns: columns, keys: keys}
+}
+
+// jsonValue returns numbers as numbers, DECIMAL as string, binary as base64 and JSON columns as is.
+func jsonValue(col *core.Column, d interface{}) string {
+	if d == nil {
+		return "null"
+	}
+	var raw []byte
+	switch x := d.(type) {
+	case []byte:
+		raw = x
+	case string:
+		raw = []byte(x)
+	default:
+		raw = []byte(fmt.Sprintf("%v", d))
+	}
+
+	var v interface{}
+	switch {
+	case col.SQLType.IsJson() && json.Valid(raw):
+		return string(raw)
+	case col.SQLType.Name == core.Bit && len(raw) <= 8:
+		var buf [8]byte
+		copy(buf[8-len(raw):], raw)
+		return strconv.FormatUint(binary.BigEndian.Uint64(buf[:]), 10)
+	case col.SQLType.Name == core.Bool:
+		return strconv.FormatBool(len(raw) > 0 && raw[0] != '0')
+	case col.SQLType.Name == core.Decimal || col.SQLType.Name == core.Numeric:
+		v = string(raw)
+	case col.SQLType.IsNumeric() && json.Valid(raw):
+		return string(raw)
+	case col.SQLType.IsBlob():
+		v = base64.StdEncoding.EncodeToString(raw)
+	default:
+		v = strings.TrimSuffix(string(raw), " +0000 UTC")
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func (e *jsonEncoder) row(dest []interface{}) string {
+	fields := make([]string, len(dest))
+	for i, d := range dest {
+		fields[i] = e.keys[i] + ":" + jsonValue(e.columns[i], d)
+	}
+	return "{" + strings.Join(fields, ",") + "}\n"
+}
+
+func (e *jsonEncoder) statement(rows []string) string {
+	return strings.Join(rows, "")
+}
+
+func (e *jsonEncoder) chunk(stmts []string) string {
+	return strings.Join(stmts, "")
+}
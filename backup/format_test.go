@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"testing"
+
+	"xorm.io/core"
+)
+
+func column(name string, sqlType string) *core.Column {
+	return &core.Column{Name: name, SQLType: core.SQLType{Name: sqlType}}
+}
+
+func TestDelimitedEncoder(t *testing.T) {
+	columns := []*core.Column{column("id", core.Int), column("name", core.Varchar), column("data", core.Blob)}
+	tests := []struct {
+		name string
+		row  []interface{}
+		csv  string
+		tsv  string
+	}{
+		{"plain", []interface{}{[]byte("1"), []byte("bob"), []byte{0x01, 0xab}}, "\"1\",\"bob\",\"01AB\"\n", "1\tbob\t01AB\n"},
+		{"null", []interface{}{[]byte("2"), nil, nil}, "\"2\",\\N,\\N\n", "2\t\\N\t\\N\n"},
+		{"empty is not null", []interface{}{[]byte("3"), []byte(""), []byte{}}, "\"3\",\"\",\"\"\n", "3\t\t\n"},
+		{
+			"special characters",
+			[]interface{}{int64(4), "a\"b,c\\d\te\nf\x00", "x"},
+			"\"4\",\"a\"\"b,c\\\\d\te\nf\\0\",\"78\"\n",
+			"4\ta\"b,c\\\\d\\te\\nf\\0\t78\n",
+		},
+	}
+	csv := &delimitedEncoder{columns: columns, sep: ",", enclose: true}
+	tsv := &delimitedEncoder{columns: columns, sep: "\t"}
+	for _, tt := range tests {
+		if got := csv.row(tt.row); got != tt.csv {
+			t.Errorf("%s: csv %q, want %q", tt.name, got, tt.csv)
+		}
+		if got := tsv.row(tt.row); got != tt.tsv {
+			t.Errorf("%s: tsv %q, want %q", tt.name, got, tt.tsv)
+		}
+	}
+
+	rows := []string{csv.row([]interface{}{[]byte("1"), []byte("a"), nil}), csv.row([]interface{}{[]byte("2"), []byte("b"), nil})}
+	if got, want := csv.chunk([]string{csv.statement(rows)}), "\"id\",\"name\",\"data\"\n\"1\",\"a\",\\N\n\"2\",\"b\",\\N\n"; got != want {
+		t.Errorf("csv chunk %q, want %q", got, want)
+	}
+	if got, want := tsv.chunk(nil), "id\tname\tdata\n"; got != want {
+		t.Errorf("tsv chunk %q, want %q", got, want)
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	tests := []struct {
+		col  *core.Column
+		v    interface{}
+		want string
+	}{
+		{column("id", core.BigInt), []byte("-42"), `{"id":-42}`},
+		{column("id", core.BigInt), int64(7), `{"id":7}`},
+		{column("id", core.BigInt), nil, `{"id":null}`},
+		{column("price", core.Decimal), []byte("12345678901234567890.10"), `{"price":"12345678901234567890.10"}`},
+		{column("ratio", core.Double), []byte("1.5e-7"), `{"ratio":1.5e-7}`},
+		{column("flag", core.Bool), []byte("1"), `{"flag":true}`},
+		{column("flag", core.Bool), []byte("0"), `{"flag":false}`},
+		{column("bits", core.Bit), []byte{0x01, 0x02}, `{"bits":258}`},
+		{column("doc", core.Json), []byte(`{"a": [1, 2]}`), `{"doc":{"a": [1, 2]}}`},
+		{column("doc", core.Json), []byte(`not json`), `{"doc":"not json"}`},
+		{column("data", core.Blob), []byte{0xff, 0x00}, `{"data":"/wA="}`},
+		{column("name", core.Varchar), []byte("a\"b\n<c>"), `{"name":"a\"b\n\u003cc\u003e"}`},
+		{column("odd \"key\"", core.Varchar), "v", `{"odd \"key\"":"v"}`},
+		{column("created", core.DateTime), "2026-10-01 12:00:00 +0000 UTC", `{"created":"2026-10-01 12:00:00"}`},
+	}
+	for _, tt := range tests {
+		e := newJSONEncoder([]*core.Column{tt.col})
+		if got := e.row([]interface{}{tt.v}); got != tt.want+"\n" {
+			t.Errorf("%s %v: got %q, want %q", tt.col.SQLType.Name, tt.v, got, tt.want)
+		}
+	}
+
+	e := newJSONEncoder([]*core.Column{column("b", core.Int), column("a", core.Varchar)})
+	rows := []string{e.row([]interface{}{[]byte("1"), []byte("x")}), e.row([]interface{}{[]byte("2"), nil})}
+	if got, want := e.chunk([]string{e.statement(rows)}), "{\"b\":1,\"a\":\"x\"}\n{\"b\":2,\"a\":null}\n"; got != want {
+		t.Errorf("chunk %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// hookEnv returns the variables of a hook, passed to the shell hooks as env and expanded in the sql hooks.
+func hookEnv(args *common.Args, stage string, event string) map[string]string {
+	return map[string]string{
+		"MYSQLDUMP_EVENT":    event,
+		"MYSQLDUMP_STAGE":    stage,
+		"MYSQLDUMP_DATABASE": args.Database,
+		"MYSQLDUMP_LOCATION": args.Outdir,
+	}
+}
+
+// fireHooks used to run the hooks of the event, sql hooks run on db and target-sql hooks on target.
+// It returns the first failure of the hooks not ignoring it.
+func fireHooks(ctx context.Context, log *xlog.Log, args *common.Args, db *sql.DB, target *sql.DB, event string, env map[string]string) error {
+	for _, hook := range args.Hooks.Event(event) {
+		name := hook.Name
+		if table := env["MYSQLDUMP_TABLE"]; table != "" {
+			name = table + "." + name
+		}
+		err := runHook(ctx, hook, db, target, env)
+		if err == nil {
+			log.Info("hook.%s[%s].done", event, name)
+			continue
+		}
+		if hook.IgnoreError {
+			log.Warning("hook.%s[%s].error:%v, ignored", event, name, err)
+			continue
+		}
+		return fmt.Errorf("hook %s.%s: %v", event, hook.Name, err)
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook common.Hook, db *sql.DB, target *sql.DB, env map[string]string) error {
+	if hook.Kind == common.HookShell {
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+		cmd.Env = os.Environ()
+		for k, v := range env {
+			cmd.Env = append(cmd.Env, k+"="+v)
+		}
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+
+	if hook.Kind == common.HookTargetSQL {
+		db = target
+	}
+	if db == nil {
+		return fmt.Errorf("no database for %s hooks", hook.Kind)
+	}
+	_, err := db.ExecContext(ctx, expandHookSQL(hook.Command, env))
+	return err
+}
+
+const hookVarPrefix = "${MYSQLDUMP_"
+
+// expandHookSQL used to replace the ${MYSQLDUMP_*} variables of the sql with their escaped values,
+// an unset one is empty. The value is escaped for the quotes it is in: a string in single or double
+// quotes, an identifier in backquotes, and it is quoted as a string outside of them. The other '$'
+// are left as is.
+func expandHookSQL(query string, env map[string]string) string {
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		if strings.HasPrefix(query[i:], hookVarPrefix) {
+			if end := strings.IndexByte(query[i:], '}'); end > 0 {
+				v := env[query[i+2:i+end]]
+				switch quote {
+				case 0:
+					b.WriteString("'" + common.EscapeString(v) + "'")
+				case '`':
+					b.WriteString(strings.Replace(v, "`", "``", -1))
+				default:
+					b.WriteString(common.EscapeString(v))
+				}
+				i += end
+				continue
+			}
+		}
+		b.WriteByte(c)
+		switch {
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		case quote != 0 && quote != '`' && c == '\\' && i+1 < len(query):
+			i++
+			b.WriteByte(query[i])
+		case c == quote:
+			quote = 0
+		}
+	}
+	return b.String()
+}
+
+// fireRunHooks used to run the hooks around fn, the after hooks always run and get the status of fn.
+func fireRunHooks(ctx context.Context, log *xlog.Log, args *common.Args, db *sql.DB, target *sql.DB, stage string, fn func() error) error {
+	if err := fireHooks(ctx, log, args, db, target, common.HookBefore, hookEnv(args, stage, common.HookBefore)); err != nil {
+		return err
+	}
+	err := fn()
+
+	env := hookEnv(args, stage, common.HookAfter)
+	env["MYSQLDUMP_STATUS"] = "done"
+	if err != nil {
+		env["MYSQLDUMP_STATUS"], env["MYSQLDUMP_ERROR"] = "failed", err.Error()
+	}
+	env["MYSQLDUMP_BYTES"] = strconv.FormatUint(args.Allbytes, 10)
+	env["MYSQLDUMP_ROWS"] = strconv.FormatUint(args.Allrows, 10)
+	if herr := fireHooks(ctx, log, args, db, target, common.HookAfter, env); err == nil {
+		err = herr
+	}
+	return err
+}
+
+// fireTableHooks used to run the table hooks of the event in a worker, a failure aborts the run.
+// The table is the one of the dump, renamed by the restore. The target-sql hooks run on target,
+// nil but for a copy.
+func fireTableHooks(log *xlog.Log, args *common.Args, engine *sqlEngine, target *sqlEngine, progress *Progress, event string, table string) {
+	if len(args.Hooks.Event(event)) == 0 {
+		return
+	}
+	var stats *TableProgress
+	if event == common.HookAfterTable {
+		t := progress.tableStatus(table)
+		stats = &t
+	}
+	_, name := args.Renames.Table(args.SourceDatabase, table)
+	fireTableEvent(log, args, engine, target, progress.stage, event, name, stats)
+}
+
+// fireTableEvent used to run the table hooks of the event on the table as named on the server,
+// stats are the bytes and rows of an after_table event.
+func fireTableEvent(log *xlog.Log, args *common.Args, engine *sqlEngine, target *sqlEngine, stage string, event string, table string, stats *TableProgress) {
+	if len(args.Hooks.Event(event)) == 0 {
+		return
+	}
+	env := hookEnv(args, stage, event)
+	env["MYSQLDUMP_TABLE"] = table
+	if stats != nil {
+		env["MYSQLDUMP_BYTES"] = strconv.FormatUint(stats.Bytes, 10)
+		env["MYSQLDUMP_ROWS"] = strconv.FormatUint(stats.Rows, 10)
+	}
+	var targetDB *sql.DB
+	if target != nil {
+		targetDB = target.DB()
+	}
+	if err := fireHooks(args.Context, log, args, engine.DB(), targetDB, event, env); err != nil {
+		log.Panic("%s.table[%s].error:%v", stage, table, err)
+	}
+}
@@ -0,0 +1,33 @@
+package backup
+
+import "testing"
+
+func TestExpandHookSQL(t *testing.T) {
+	env := map[string]string{
+		"MYSQLDUMP_TABLE":  "odd`name",
+		"MYSQLDUMP_ERROR":  `Table 'db.t' doesn't exist`,
+		"MYSQLDUMP_STATUS": "failed",
+		"MYSQLDUMP_ROWS":   "42",
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"ANALYZE TABLE `${MYSQLDUMP_TABLE}`", "ANALYZE TABLE `odd``name`"},
+		{"INSERT INTO log VALUES ('${MYSQLDUMP_STATUS}', '${MYSQLDUMP_ERROR}')", `INSERT INTO log VALUES ('failed', 'Table \'db.t\' doesn\'t exist')`},
+		{`INSERT INTO log VALUES ("${MYSQLDUMP_ERROR}")`, `INSERT INTO log VALUES ("Table \'db.t\' doesn\'t exist")`},
+		{"INSERT INTO log VALUES (${MYSQLDUMP_ROWS}, ${MYSQLDUMP_ERROR})", `INSERT INTO log VALUES ('42', 'Table \'db.t\' doesn\'t exist')`},
+		// unset variables are empty.
+		{"SELECT '${MYSQLDUMP_BYTES}'", "SELECT ''"},
+		// the other '$' are left as is.
+		{"SELECT '$1', '$$', '$name', '${OTHER}', ${MYSQLDUMP_ROWS", "SELECT '$1', '$$', '$name', '${OTHER}', ${MYSQLDUMP_ROWS"},
+		// escaped quotes don't end the string.
+		{`SELECT 'it\'s ${MYSQLDUMP_STATUS}', 'a''b', ${MYSQLDUMP_STATUS}`, `SELECT 'it\'s failed', 'a''b', 'failed'`},
+		{"SELECT \"`\", `${MYSQLDUMP_STATUS}`", "SELECT \"`\", `failed`"},
+	}
+	for _, tt := range tests {
+		if got := expandHookSQL(tt.query, env); got != tt.want {
+			t.Errorf("expandHookSQL(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,416 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// Statement kinds of a sql stream.
+const (
+	stmtSession  = iota // SET/USE, applied to every connection
+	stmtDatabase        // CREATE DATABASE
+	stmtSkip            // LOCK TABLES, DISABLE KEYS, BEGIN...
+	stmtData            // INSERT/REPLACE, loaded by the workers
+	stmtTable           // table DDL, runs after the pending data of the table
+	stmtDeferred        // views, routines, triggers and events, run after all data
+	stmtBarrier         // anything else, runs after all pending data
+)
+
+var (
+	versionComment = regexp.MustCompile(`/\*!\d*|\*/`)
+	plainComment   = regexp.MustCompile(`(?s)/\*[^!].*?\*/`)
+	spaces         = regexp.MustCompile(`\s+`)
+	identifier     = "(?:`(?:[^`]|``)+`|[\\w$]+)"
+	tableName      = fmt.Sprintf(`(?:%s\s*\.\s*)?(%s)`, identifier, identifier)
+	dataStmt       = regexp.MustCompile(`(?is)^(?:INSERT|REPLACE)\s+(?:(?:LOW_PRIORITY|DELAYED|HIGH_PRIORITY|IGNORE)\s+)*(?:INTO\s+)?` + tableName)
+	tableStmt      = regexp.MustCompile(`(?is)^(?:CREATE\s+(?:TEMPORARY\s+)?TABLE|DROP\s+(?:TEMPORARY\s+)?TABLE|ALTER\s+(?:IGNORE\s+)?TABLE|TRUNCATE(?:\s+TABLE)?|RENAME\s+TABLE)\s+(?:IF\s+(?:NOT\s+)?EXISTS\s+)?` + tableName)
+	keysStmt       = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+\S+\s+(?:DISABLE|ENABLE)\s+KEYS`)
+	deferredStmt   = regexp.MustCompile(`(?i)^(?:CREATE|DROP|ALTER)(?: OR REPLACE)?(?: ALGORITHM ?= ?\w+)?(?: DEFINER ?= ?\S+)?(?: SQL SECURITY \w+)?(?: AGGREGATE)? (VIEW|FUNCTION|PROCEDURE|TRIGGER|EVENT)\b`)
+	useStmt        = regexp.MustCompile(`(?i)^USE\s+(` + identifier + `)`)
+	skipStmt       = regexp.MustCompile(`(?is)^(?:LOCK\s+TABLES|UNLOCK\s+TABLES|BEGIN|START\s+TRANSACTION|COMMIT)\b`)
+)
+
+// classify returns the kind of the statement and the table it belongs to, or the lowercase
+// object type(view, function, procedure, trigger, event) of the deferred ones.
+func classify(stmt string) (int, string) {
+	head := stmt
+	if len(head) > 1024 {
+		head = head[:1024]
+	}
+	// "/*!50001 CREATE ALGORITHM=UNDEFINED */ /*!50013 DEFINER=... */ /*!50001 VIEW" => "CREATE ALGORITHM=UNDEFINED DEFINER=... VIEW"
+	head = plainComment.ReplaceAllString(head, " ")
+	head = versionComment.ReplaceAllString(head, " ")
+	head = strings.TrimSpace(spaces.ReplaceAllString(head, " "))
+	upper := strings.ToUpper(head)
+	switch {
+	case strings.HasPrefix(upper, "SET ") || strings.HasPrefix(upper, "USE "):
+		return stmtSession, ""
+	case strings.HasPrefix(upper, "CREATE DATABASE") || strings.HasPrefix(upper, "CREATE SCHEMA"):
+		return stmtDatabase, ""
+	case skipStmt.MatchString(head) || keysStmt.MatchString(head):
+		return stmtSkip, ""
+	}
+	if m := dataStmt.FindStringSubmatch(head); m != nil {
+		return stmtData, unquoteIdent(m[1])
+	}
+	if m := deferredStmt.FindStringSubmatch(head); m != nil {
+		return stmtDeferred, strings.ToLower(m[1])
+	}
+	if m := tableStmt.FindStringSubmatch(head); m != nil {
+		return stmtTable, unquoteIdent(m[1])
+	}
+	return stmtBarrier, ""
+}
+
+func unquoteIdent(name string) string {
+	if strings.HasPrefix(name, "`") {
+		return strings.Replace(name[1:len(name)-1], "``", "`", -1)
+	}
+	return name
+}
+
+// session holds the SET/USE statements of the stream, a connection replays
+// them in order to catch up with the state the next statement expects.
+type session struct {
+	mu    sync.Mutex
+	stmts []string
+}
+
+func (s *session) add(stmt string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stmts = append(s.stmts, stmt)
+	return len(s.stmts)
+}
+
+func (s *session) version() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.stmts)
+}
+
+// catchUp used to apply the session statements [from, to) on conn.
+func (s *session) catchUp(conn *sql.Conn, from, to int) error {
+	s.mu.Lock()
+	stmts := s.stmts[from:to]
+	s.mu.Unlock()
+	for _, stmt := range stmts {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return fmt.Errorf("%.80s: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// sessionConn is a connection following the session statements, it's opened again after a failure.
+type sessionConn struct {
+	engine  *sqlEngine
+	sess    *session
+	conn    *sql.Conn
+	applied int
+}
+
+// exec used to call fn on the connection caught up with the session statements [0, version).
+// The connection is dropped if fn fails, the next call replays the session on a new one.
+func (c *sessionConn) exec(ctx context.Context, version int, fn func(conn *sql.Conn) error) error {
+	if c.conn == nil {
+		conn, err := c.engine.DB().Conn(ctx)
+		if err != nil {
+			return &unsentError{err}
+		}
+		c.conn, c.applied = conn, 0
+	}
+	err := c.sess.catchUp(c.conn, c.applied, version)
+	if err != nil {
+		err = &unsentError{err}
+	} else {
+		c.applied = version
+		err = fn(c.conn)
+	}
+	if err != nil {
+		c.close()
+	}
+	return err
+}
+
+func (c *sessionConn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// batch is a group of data statements of one table, executed in one transaction.
+type batch struct {
+	table   string
+	stmts   []string
+	size    int
+	session int
+}
+
+// loadStream used to import a single sql file, made by this tool, mysqldump or navicat.
+// The stream is split into statements: table DDL runs in order on one connection, the INSERTs
+// are batched per table and loaded by Threads workers, views, routines and triggers run at the end.
+// If args.Database is set, the CREATE DATABASE and USE statements of the stream are skipped.
+func loadStream(log *xlog.Log, args *common.Args, engine *sqlEngine, r io.Reader, size int64, f *failure) {
+	t := time.Now()
+	ctx := args.Context
+	progress := newProgress(log, args, "restoring")
+	progress.addTotal("stream", uint64(size), 0)
+	progress.setStatus("stream", statusRunning)
+	progress.Start()
+
+	sess := &session{}
+	if args.Database != "" {
+		sess.add(fmt.Sprintf("USE `%s`", args.Database))
+	}
+	sess.add("SET FOREIGN_KEY_CHECKS=0")
+	args.MaxAllowedPacket = maxAllowedPacket(log, engine, "restoring")
+
+	// execOn used to run the statement on c, caught up with the session it was seen with.
+	execOn := func(c *sessionConn, version int, stmt string) {
+		err := retryWrite(log, args, "restoring", fmt.Sprintf("restoring.stream.statement[%.40s]", stmt), func() error {
+			return c.exec(ctx, version, func(conn *sql.Conn) error {
+				start := time.Now()
+				_, err := conn.ExecContext(ctx, stmt)
+				observeQuery("restoring", start, err)
+				return err
+			})
+		})
+		if err != nil {
+			log.Panic("restoring.stream.statement[%.80s].error:%+v", stmt, err)
+		}
+	}
+	mconn := &sessionConn{engine: engine, sess: sess}
+	defer mconn.close()
+	exec := func(stmt string) { execOn(mconn, sess.version(), stmt) }
+
+	// workers
+	var wg sync.WaitGroup
+	pending := map[string]*sync.WaitGroup{}
+	var pendingMu sync.Mutex
+	tableWait := func(table string) *sync.WaitGroup {
+		pendingMu.Lock()
+		defer pendingMu.Unlock()
+		w, ok := pending[table]
+		if !ok {
+			w = &sync.WaitGroup{}
+			pending[table] = w
+		}
+		return w
+	}
+	jobs := make(chan *batch, args.Threads)
+	var closeOnce sync.Once
+	closeJobs := func() { closeOnce.Do(func() { close(jobs) }) }
+	defer closeJobs()
+	for i := 0; i < args.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wconn := &sessionConn{engine: engine, sess: sess}
+			defer wconn.close()
+			// the worker drains the jobs after a failure, the reader waits for their tables.
+			for b := range jobs {
+				func() {
+					defer tableWait(b.table).Done()
+					defer f.catch()
+					checkContext(args)
+					metricWorkers.Inc("restoring")
+					defer metricWorkers.Dec("restoring")
+					// a batch is one transaction, it runs again as a whole if it was rolled back.
+					err := retryWrite(log, args, "restoring", fmt.Sprintf("restoring.table[%s]", b.table), func() error {
+						return wconn.exec(ctx, b.session, func(conn *sql.Conn) error {
+							start := time.Now()
+							err := execBatch(ctx, conn, b.stmts)
+							observeQuery("restoring", start, err)
+							return err
+						})
+					})
+					if err != nil {
+						log.Panic("restoring.table[%s].error:%+v", b.table, err)
+					}
+					metricChunks.Inc("restoring")
+				}()
+			}
+		}()
+	}
+
+	var cur *batch
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		tableWait(cur.table).Add(1)
+		jobs <- cur
+		cur = nil
+	}
+	waitAll := func() {
+		flush()
+		pendingMu.Lock()
+		waits := make([]*sync.WaitGroup, 0, len(pending))
+		for _, w := range pending {
+			waits = append(waits, w)
+		}
+		pendingMu.Unlock()
+		for _, w := range waits {
+			w.Wait()
+		}
+	}
+
+	// dataTable is the table the data statements are loading, its after_table hooks run once the
+	// stream goes on with another table and its batches are done. The bytes of a table are the
+	// ones of its statements, the rows are not counted.
+	var dataTable string
+	var dataBytes uint64
+	endTable := func() {
+		if dataTable == "" {
+			return
+		}
+		flush()
+		tableWait(dataTable).Wait()
+		f.check()
+		fireTableEvent(log, args, engine, nil, progress.stage, common.HookAfterTable, dataTable, &TableProgress{Name: dataTable, Bytes: dataBytes})
+		dataTable, dataBytes = "", 0
+	}
+
+	type deferred struct {
+		stmt    string
+		session int
+	}
+	var deferreds []deferred
+
+	reader := common.NewStatementReader(r)
+	var read uint64
+	// current is the database of the stream the statements run in.
+	var current string
+	for {
+		stmt, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		common.AssertNil(err)
+		checkContext(args)
+		progress.add("stream", reader.BytesRead()-read, 0)
+		read = reader.BytesRead()
+
+		if m := useStmt.FindStringSubmatch(stmt); m != nil {
+			current = unquoteIdent(m[1])
+			if args.Database != "" {
+				args.Renames.SetDefault(current, args.Database)
+			}
+		}
+		stmt = args.Renames.Rewrite(stmt, current)
+
+		kind, table := classify(stmt)
+		if kind != stmtData {
+			flush()
+		}
+		switch kind {
+		case stmtSession:
+			if args.Database != "" && strings.HasPrefix(strings.ToUpper(stmt), "USE") {
+				continue
+			}
+			sess.add(stmt)
+		case stmtDatabase:
+			if args.Database != "" {
+				log.Info("restoring.skip[%.40s]", stmt)
+				continue
+			}
+			exec(stmt)
+		case stmtSkip:
+		case stmtData:
+			if args.NoData {
+				continue
+			}
+			if table != dataTable {
+				endTable()
+				fireTableEvent(log, args, engine, nil, progress.stage, common.HookBeforeTable, table, nil)
+				dataTable = table
+			}
+			dataBytes += uint64(len(stmt))
+			if cur != nil && (cur.table != table || cur.size >= args.StmtSize) {
+				flush()
+			}
+			if cur == nil {
+				cur = &batch{table: table, session: sess.version()}
+			}
+			for _, s := range splitStatement(log, args, table, stmt) {
+				cur.stmts = append(cur.stmts, s)
+				cur.size += len(s)
+			}
+		case stmtTable:
+			if args.NoSchema {
+				continue
+			}
+			tableWait(table).Wait()
+			exec(stmt)
+			log.Info("restoring.schema.table[%s].%.20s", table, stmt)
+		case stmtDeferred:
+			if skipDeferred(args, table) {
+				continue
+			}
+			deferreds = append(deferreds, deferred{stmt: stmt, session: sess.version()})
+		default:
+			waitAll()
+			exec(stmt)
+		}
+	}
+	endTable()
+	waitAll()
+	closeJobs()
+	wg.Wait()
+	f.check()
+
+	// views, routines and triggers on a new connection, replaying the session they were seen with.
+	dconn := &sessionConn{engine: engine, sess: sess}
+	defer dconn.close()
+	for _, d := range deferreds {
+		execOn(dconn, d.session, d.stmt)
+	}
+	log.Info("restoring.schema.deferred[%d]", len(deferreds))
+
+	progress.setStatus("stream", statusDone)
+	progress.Stop()
+	elapsedStr, elapsed := time.Since(t).String(), time.Since(t).Seconds()
+	log.Info("restoring.all.done.cost[%s].allbytes[%.2fMB].rate[%.2fMB/s]", elapsedStr, common.MB(args.Allbytes), common.MB(args.Allbytes)/elapsed)
+}
+
+// skipDeferred returns whether the deferred statement of the object type is skipped,
+// the triggers and events go with the tables.
+func skipDeferred(args *common.Args, object string) bool {
+	switch object {
+	case "view":
+		return args.NoViews
+	case "function", "procedure":
+		return args.NoRoutines
+	}
+	return args.NoSchema
+}
+
+// execBatch used to execute the statements in one transaction.
+func execBatch(ctx context.Context, conn *sql.Conn, stmts []string) error {
+	if len(stmts) == 1 {
+		_, err := conn.ExecContext(ctx, stmts[0])
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,248 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		stmt  string
+		kind  int
+		table string
+	}{
+		// mysqldump
+		{"/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */", stmtSession, ""},
+		{"/*!40101 SET NAMES utf8mb4 */", stmtSession, ""},
+		{"USE `db`", stmtSession, ""},
+		{"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `db` /*!40100 DEFAULT CHARACTER SET utf8mb4 */", stmtDatabase, ""},
+		{"DROP TABLE IF EXISTS `t1`", stmtTable, "t1"},
+		{"CREATE TABLE `t1` (\n  `id` int NOT NULL\n) ENGINE=InnoDB", stmtTable, "t1"},
+		{"LOCK TABLES `t1` WRITE", stmtSkip, ""},
+		{"/*!40000 ALTER TABLE `t1` DISABLE KEYS */", stmtSkip, ""},
+		{"INSERT INTO `t1` VALUES (1),(2)", stmtData, "t1"},
+		{"/*!40000 ALTER TABLE `t1` ENABLE KEYS */", stmtSkip, ""},
+		{"UNLOCK TABLES", stmtSkip, ""},
+		{"/*!50001 CREATE ALGORITHM=UNDEFINED */\n/*!50013 DEFINER=`root`@`%` SQL SECURITY DEFINER */\n/*!50001 VIEW `v` AS select 1 AS `1` */", stmtDeferred, "view"},
+		{"/*!50003 CREATE*/ /*!50017 DEFINER=`root`@`%`*/ /*!50003 TRIGGER `tr` BEFORE INSERT ON `t1` FOR EACH ROW SET NEW.id = 1 */", stmtDeferred, "trigger"},
+		{"CREATE DEFINER=`root`@`%` PROCEDURE `p`()\nBEGIN\n  SELECT 1;\nEND", stmtDeferred, "procedure"},
+		// navicat
+		{"SET FOREIGN_KEY_CHECKS = 0", stmtSession, ""},
+		{"BEGIN", stmtSkip, ""},
+		{"COMMIT", stmtSkip, ""},
+		{"INSERT INTO `t1` VALUES (1, 'a')", stmtData, "t1"},
+		{"CREATE ALGORITHM = UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select 1", stmtDeferred, "view"},
+		// others
+		{"/* a comment */ INSERT IGNORE INTO db.`odd``name` (a) VALUES (1)", stmtData, "odd`name"},
+		{"REPLACE LOW_PRIORITY t2 VALUES (1)", stmtData, "t2"},
+		{"TRUNCATE `t1`", stmtTable, "t1"},
+		{"ALTER TABLE `t1` ADD INDEX `i` (`id`)", stmtTable, "t1"},
+		{"START TRANSACTION", stmtSkip, ""},
+		{"DROP FUNCTION IF EXISTS `f`", stmtDeferred, "function"},
+		{"GRANT SELECT ON db.* TO 'u'@'%'", stmtBarrier, ""},
+	}
+	for _, tt := range tests {
+		kind, table := classify(tt.stmt)
+		if kind != tt.kind || table != tt.table {
+			t.Errorf("classify(%q) = %d, %q, want %d, %q", tt.stmt, kind, table, tt.kind, tt.table)
+		}
+	}
+}
+
+// loadStreamLog returns the statements the stream ran, with the index of each of them.
+func loadStreamLog(t *testing.T, args *common.Args, input string) ([]string, map[string]int) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT @@max_allowed_packet", []string{"packet"}, []driver.Value{[]byte("67108864")})
+	engine := &sqlEngine{db: db}
+	log := xlog.NewXLog(ioutil.Discard)
+	err := run(args, func(fail *failure) {
+		loadStream(log, args, engine, strings.NewReader(input), int64(len(input)), fail)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := f.statements()
+	index := map[string]int{}
+	for i, s := range stmts {
+		index[s[3:]] = i
+	}
+	return stmts, index
+}
+
+// checkSession checks the statements of every connection follow the session statements, in order.
+func checkSession(t *testing.T, stmts []string, session []string) {
+	conns := map[string][]string{}
+	for _, s := range stmts {
+		if !strings.HasPrefix(s[3:], "SELECT @@") {
+			conns[s[:3]] = append(conns[s[:3]], s[3:])
+		}
+	}
+	for conn, list := range conns {
+		if len(list) < len(session) || strings.Join(list[:len(session)], ";") != strings.Join(session, ";") {
+			t.Errorf("connection %s: statements %q, want the session %q first", conn, list, session)
+		}
+	}
+}
+
+func TestLoadStreamMysqldump(t *testing.T) {
+	input := `-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: db1
+-- ------------------------------------------------------
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+
+CREATE DATABASE /*!32312 IF NOT EXISTS*/ ` + "`db1`" + ` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;
+
+USE ` + "`db1`" + `;
+
+--
+-- Table structure for table ` + "`t1`" + `
+--
+
+DROP TABLE IF EXISTS ` + "`t1`" + `;
+CREATE TABLE ` + "`t1`" + ` (
+  ` + "`id`" + ` int NOT NULL
+) ENGINE=InnoDB;
+
+LOCK TABLES ` + "`t1`" + ` WRITE;
+/*!40000 ALTER TABLE ` + "`t1`" + ` DISABLE KEYS */;
+INSERT INTO ` + "`t1`" + ` VALUES (1),(2);
+INSERT INTO ` + "`t1`" + ` VALUES (3);
+/*!40000 ALTER TABLE ` + "`t1`" + ` ENABLE KEYS */;
+UNLOCK TABLES;
+
+DROP TABLE IF EXISTS ` + "`t2`" + `;
+CREATE TABLE ` + "`t2`" + ` (
+  ` + "`id`" + ` int NOT NULL
+) ENGINE=InnoDB;
+INSERT INTO ` + "`t2`" + ` VALUES (1);
+
+DELIMITER ;;
+/*!50003 CREATE*/ /*!50017 DEFINER=` + "`root`@`%`" + `*/ /*!50003 TRIGGER ` + "`tr`" + ` BEFORE INSERT ON ` + "`t1`" + ` FOR EACH ROW SET NEW.id = NEW.id + 1 */;;
+DELIMITER ;
+
+/*!50001 CREATE ALGORITHM=UNDEFINED */
+/*!50013 DEFINER=` + "`root`@`%`" + ` SQL SECURITY DEFINER */
+/*!50001 VIEW ` + "`v`" + ` AS select 1 AS ` + "`1`" + ` */;
+`
+	args := &common.Args{Context: context.Background(), Database: "db2", Threads: 2, StmtSize: 1 << 20, IntervalMs: 1000, Retries: 1, RetryBudget: 1}
+	stmts, index := loadStreamLog(t, args, input)
+
+	checkSession(t, stmts, []string{"USE `db2`", "SET FOREIGN_KEY_CHECKS=0", "/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */", "/*!40101 SET NAMES utf8mb4 */"})
+	for _, s := range stmts {
+		for _, skipped := range []string{"CREATE DATABASE", "USE `db1`", "LOCK TABLES", "DISABLE KEYS"} {
+			if strings.Contains(s, skipped) {
+				t.Errorf("statement %q ran, want %s skipped", s, skipped)
+			}
+		}
+	}
+
+	order := []string{
+		"DROP TABLE IF EXISTS `t1`",
+		"CREATE TABLE `t1` (\n  `id` int NOT NULL\n) ENGINE=InnoDB",
+		"INSERT INTO `t1` VALUES (1),(2)",
+		"INSERT INTO `t1` VALUES (3)",
+	}
+	t2 := []string{
+		"DROP TABLE IF EXISTS `t2`",
+		"CREATE TABLE `t2` (\n  `id` int NOT NULL\n) ENGINE=InnoDB",
+		"INSERT INTO `t2` VALUES (1)",
+	}
+	deferred := []string{
+		"/*!50003 CREATE*/ /*!50017 DEFINER=`root`@`%`*/ /*!50003 TRIGGER `tr` BEFORE INSERT ON `t1` FOR EACH ROW SET NEW.id = NEW.id + 1 */",
+		"/*!50001 CREATE ALGORITHM=UNDEFINED */\n/*!50013 DEFINER=`root`@`%` SQL SECURITY DEFINER */\n/*!50001 VIEW `v` AS select 1 AS `1` */",
+	}
+	for _, list := range [][]string{order, t2, deferred, {"CREATE TABLE `t1` (\n  `id` int NOT NULL\n) ENGINE=InnoDB", "DROP TABLE IF EXISTS `t2`"}} {
+		for i, s := range list {
+			if _, ok := index[s]; !ok {
+				t.Fatalf("statement %q didn't run: %q", s, stmts)
+			}
+			if i > 0 && index[s] < index[list[i-1]] {
+				t.Errorf("statement %q ran before %q", s, list[i-1])
+			}
+		}
+	}
+	// the views and triggers run after all the data.
+	for _, s := range append(order, t2...) {
+		if index[s] > index[deferred[0]] {
+			t.Errorf("statement %q ran after the deferred %q", s, deferred[0])
+		}
+	}
+}
+
+func TestLoadStreamNavicat(t *testing.T) {
+	header := `/*
+ Navicat Premium Data Transfer
+
+ Source Server Type    : MySQL
+ Source Schema         : db1
+
+ Date: 01/10/2026 12:00:00
+*/`
+	input := header + `
+
+SET NAMES utf8mb4;
+SET FOREIGN_KEY_CHECKS = 0;
+
+-- ----------------------------
+-- Table structure for t1
+-- ----------------------------
+DROP TABLE IF EXISTS ` + "`t1`" + `;
+CREATE TABLE ` + "`t1`" + `  (
+  ` + "`id`" + ` int NOT NULL,
+  ` + "`name`" + ` varchar(10) NULL DEFAULT NULL
+) ENGINE = InnoDB;
+
+-- ----------------------------
+-- Records of t1
+-- ----------------------------
+BEGIN;
+INSERT INTO ` + "`t1`" + ` VALUES (1, 'a;b');
+INSERT INTO ` + "`t1`" + ` VALUES (2, 'c');
+COMMIT;
+
+-- ----------------------------
+-- View structure for v
+-- ----------------------------
+DROP VIEW IF EXISTS ` + "`v`" + `;
+CREATE ALGORITHM = UNDEFINED SQL SECURITY DEFINER VIEW ` + "`v`" + ` AS select ` + "`t1`.`id`" + ` AS ` + "`id`" + ` from ` + "`t1`" + `;
+
+SET FOREIGN_KEY_CHECKS = 1;
+`
+	args := &common.Args{Context: context.Background(), Threads: 1, StmtSize: 1 << 20, IntervalMs: 1000, Retries: 1, RetryBudget: 1}
+	stmts, index := loadStreamLog(t, args, input)
+
+	// the comments go with the statement after them.
+	checkSession(t, stmts, []string{"SET FOREIGN_KEY_CHECKS=0", header + "\n\nSET NAMES utf8mb4", "SET FOREIGN_KEY_CHECKS = 0"})
+	// the rows of the table are one batch, in one transaction.
+	order := []string{
+		"DROP TABLE IF EXISTS `t1`",
+		"CREATE TABLE `t1`  (\n  `id` int NOT NULL,\n  `name` varchar(10) NULL DEFAULT NULL\n) ENGINE = InnoDB",
+		"BEGIN isolation=Default read_only=false",
+		"INSERT INTO `t1` VALUES (1, 'a;b')",
+		"INSERT INTO `t1` VALUES (2, 'c')",
+		"COMMIT",
+		"DROP VIEW IF EXISTS `v`",
+		"CREATE ALGORITHM = UNDEFINED SQL SECURITY DEFINER VIEW `v` AS select `t1`.`id` AS `id` from `t1`",
+	}
+	for i, s := range order {
+		if _, ok := index[s]; !ok {
+			t.Fatalf("statement %q didn't run: %q", s, stmts)
+		}
+		if i > 0 && index[s] < index[order[i-1]] {
+			t.Errorf("statement %q ran before %q", s, order[i-1])
+		}
+	}
+	if conn := stmts[index["COMMIT"]][:3]; stmts[index["INSERT INTO `t1` VALUES (1, 'a;b')"]][:3] != conn {
+		t.Errorf("the batch ran on several connections: %q", stmts)
+	}
+}
@@ -0,0 +1,324 @@
+package backup
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// Files tuple.
+type Files struct {
+	procedures []string
+	functions  []string
+	tables     []string
+	views      []string
+	datas      []string
+	// sizes maps the file names to their sizes.
+	sizes map[string]int64
+}
+
+var (
+	procedureSuffix = "-procedure.sql"
+	functionSuffix  = "-function.sql"
+	tableSuffix     = "-table.sql"
+	viewSuffix      = "-view.sql"
+	dataSuffixes    = []string{".sql", ".csv", ".tsv"}
+)
+
+func loadFiles(log *xlog.Log, storage common.Storage) *Files {
+	list, err := storage.List("")
+	if err != nil {
+		log.Panicf("loader.file.list.error:%+v", err)
+	}
+
+	files := &Files{sizes: map[string]int64{}}
+	for _, f := range list {
+		path := f.Name
+		files.sizes[path] = f.Size
+		switch {
+		case strings.HasSuffix(path, tableSuffix):
+			files.tables = append(files.tables, path)
+		case strings.HasSuffix(path, functionSuffix):
+			files.functions = append(files.functions, path)
+		case strings.HasSuffix(path, procedureSuffix):
+			files.procedures = append(files.procedures, path)
+		case strings.HasSuffix(path, viewSuffix):
+			files.views = append(files.views, path)
+		default:
+			for _, suffix := range dataSuffixes {
+				if strings.HasSuffix(path, suffix) {
+					files.datas = append(files.datas, path)
+				}
+			}
+		}
+	}
+	return files
+}
+
+// readFile used to read the dump file and check it against the manifest.
+func readFile(log *xlog.Log, args *common.Args, file string) []byte {
+	data, err := common.ReadAll(args.Storage, file)
+	common.AssertNil(err)
+	if args.Manifest == nil {
+		return data
+	}
+	if err := args.Manifest.Check(filepath.Base(file), data); err != nil {
+		if !args.IgnoreChecksum {
+			log.Panic("restoring.manifest.check.error:%+v", err)
+		}
+		log.Warning("restoring.manifest.check.error:%+v", err)
+	}
+	return data
+}
+
+// openFile used to read the dump file and decrypt it.
+func openFile(log *xlog.Log, args *common.Args, file string) []byte {
+	data, err := args.Cipher.Open(readFile(log, args, file))
+	if err != nil {
+		log.Panic("restoring.file[%s].decrypt.error:%+v", filepath.Base(file), err)
+	}
+	return data
+}
+
+func restoreSchema(log *xlog.Log, engine *sqlEngine, args *common.Args, schemas []string, key string) {
+	conn := restoreConn(engine)
+	defer conn.close()
+	for _, schema := range schemas {
+		name := strings.TrimSuffix(filepath.Base(schema), fmt.Sprintf("-%s.sql", key))
+		if key == "table" || key == "view" {
+			_, name = args.Renames.Table(args.SourceDatabase, name)
+		}
+		data := openFile(log, args, schema)
+		query := args.Renames.Rewrite(common.BytesToString(data), args.SourceDatabase)
+		if key == "table" && incremental(args) {
+			// keep the tables of the base, only create the new ones.
+			query = strings.Replace(query, "CREATE TABLE ", "CREATE TABLE IF NOT EXISTS ", 1)
+		} else {
+			dropQuery := fmt.Sprintf("DROP %s IF EXISTS `%s`", strings.ToUpper(key), name)
+			common.AssertNil(execRetry(log, args, conn, fmt.Sprintf("restoring.schema.%s[%s]", key, name), dropQuery))
+		}
+
+		common.AssertNil(execRetry(log, args, conn, fmt.Sprintf("restoring.schema.%s[%s]", key, name), query))
+		log.Info("restoring.schema.%s[%s]", key, name)
+	}
+}
+
+func dataTableName(table string) (string, string) {
+	part := "0"
+	base := filepath.Base(table)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	splits := strings.Split(name, ".")
+	if len(splits) > 1 {
+		part = splits[1]
+	}
+	return splits[0], part
+}
+
+func restoreData(log *xlog.Log, args *common.Args, table string, engine *sqlEngine) int {
+	tb, part := dataTableName(table)
+	_, tb = args.Renames.Table(args.SourceDatabase, tb)
+
+	log.Info("restoring.tables[%s].parts[%s]", tb, part)
+
+	var err error
+	bytes := openFile(log, args, table)
+	if ext := filepath.Ext(table); ext == ".csv" || ext == ".tsv" {
+		err = loadDataInfile(log, args, engine, fmt.Sprintf("restoring.tables[%s].parts[%s]", tb, part), tb, table, bytes)
+		common.AssertNil(err)
+		metricChunks.Inc("restoring")
+		log.Info("restoring.tables[%s].parts[%s].done...", tb, part)
+		return len(bytes)
+	}
+	conn := restoreConn(engine)
+	defer conn.close()
+	sqlStr := common.BytesToString(bytes)
+	sqls := strings.Split(sqlStr, ";\n")
+	for _, sql := range sqls {
+		if sql != "" {
+			checkContext(args)
+			for _, stmt := range splitStatement(log, args, tb, args.Renames.Rewrite(sql, args.SourceDatabase)) {
+				err = execRetry(log, args, conn, fmt.Sprintf("restoring.tables[%s].parts[%s]", tb, part), stmt)
+				common.AssertNil(err)
+			}
+		}
+	}
+	metricChunks.Inc("restoring")
+	log.Info("restoring.tables[%s].parts[%s].done...", tb, part)
+	return len(bytes)
+}
+
+// restoreConn returns a connection restoring the dump, the foreign keys are not checked on it.
+func restoreConn(engine *sqlEngine) *sessionConn {
+	sess := &session{}
+	sess.add("SET FOREIGN_KEY_CHECKS=0")
+	return &sessionConn{engine: engine, sess: sess}
+}
+
+// execRetry used to execute the statement on conn, retried after a transient error leaving nothing applied.
+func execRetry(log *xlog.Log, args *common.Args, conn *sessionConn, what string, query string) error {
+	return retryWrite(log, args, "restoring", what, func() error {
+		return conn.exec(args.Context, conn.sess.version(), func(c *sql.Conn) error {
+			start := time.Now()
+			_, err := c.ExecContext(args.Context, query)
+			observeQuery("restoring", start, err)
+			return err
+		})
+	})
+}
+
+// loadDataInfile used to load a csv/tsv chunk with LOAD DATA LOCAL INFILE, the data is streamed
+// through a registered reader, so the server needs local_infile enabled.
+func loadDataInfile(log *xlog.Log, args *common.Args, engine *sqlEngine, what string, table string, file string, data []byte) error {
+	ext := filepath.Ext(file)
+	header := common.BytesToString(data)
+	if i := strings.IndexByte(header, '\n'); i >= 0 {
+		header = header[:i]
+	}
+
+	var names []string
+	fields := "FIELDS TERMINATED BY '\\t' ESCAPED BY '\\\\'"
+	if ext == ".csv" {
+		r := csv.NewReader(strings.NewReader(header))
+		r.LazyQuotes = true
+		var err error
+		if names, err = r.Read(); err != nil {
+			return fmt.Errorf("%s bad header: %v", file, err)
+		}
+		fields = "FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\"' ESCAPED BY '\\\\'"
+	} else {
+		names = strings.Split(header, "\t")
+	}
+
+	// binary columns are hex encoded by the dumper.
+	binary := map[string]bool{}
+	qr, err := engine.QueryString(fmt.Sprintf("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' AND DATA_TYPE IN ('binary', 'varbinary', 'tinyblob', 'blob', 'mediumblob', 'longblob')", args.Database, table))
+	if err != nil {
+		return err
+	}
+	for _, c := range qr {
+		binary[c["COLUMN_NAME"]] = true
+	}
+
+	cols := make([]string, len(names))
+	var sets []string
+	for i, name := range names {
+		if binary[name] {
+			cols[i] = fmt.Sprintf("@v%d", i)
+			sets = append(sets, fmt.Sprintf("`%s` = UNHEX(@v%d)", name, i))
+		} else {
+			cols[i] = fmt.Sprintf("`%s`", name)
+		}
+	}
+	replace := ""
+	if incremental(args) {
+		replace = "REPLACE "
+	}
+	query := fmt.Sprintf("LOAD DATA LOCAL INFILE 'Reader::%s' %sINTO TABLE `%s` CHARACTER SET utf8mb4 %s LINES TERMINATED BY '\\n' IGNORE 1 LINES (%s)",
+		filepath.Base(file), replace, table, fields, strings.Join(cols, ", "))
+	if len(sets) > 0 {
+		query += " SET " + strings.Join(sets, ", ")
+	}
+
+	mysql.RegisterReaderHandler(filepath.Base(file), func() io.Reader { return bytes.NewReader(data) })
+	defer mysql.DeregisterReaderHandler(filepath.Base(file))
+
+	conn := restoreConn(engine)
+	defer conn.close()
+	return execRetry(log, args, conn, what, query)
+}
+
+// loadDir used to restore the dump in the storage.
+func loadDir(log *xlog.Log, args *common.Args, engine *sqlEngine, f *failure) {
+	t := time.Now()
+	files := loadFiles(log, args.Storage)
+	if args.NoSchema {
+		files.tables = nil
+	}
+	if args.NoRoutines {
+		files.functions, files.procedures = nil, nil
+	}
+	if args.NoViews {
+		files.views = nil
+	}
+	if args.NoData {
+		files.datas = nil
+	}
+	manifest, err := common.ReadManifest(args.Storage, args.Cipher)
+	common.AssertNil(err)
+	if manifest == nil {
+		log.Warning("restoring.manifest[%s].not.found, skip checking files", common.ManifestName)
+	}
+	args.Manifest = manifest
+	if incremental(args) {
+		log.Info("restoring.incremental.from[%s], upserting the rows", manifest.IncrementalFrom)
+	}
+	args.MaxAllowedPacket = maxAllowedPacket(log, engine, "restoring")
+	var schemas sync.WaitGroup
+	for _, routine := range []struct {
+		files []string
+		key   string
+	}{{files.functions, "function"}, {files.procedures, "procedure"}} {
+		schemas.Add(1)
+		go func(files []string, key string) {
+			defer schemas.Done()
+			defer f.catch()
+			restoreSchema(log, engine, args, files, key)
+		}(routine.files, routine.key)
+	}
+	restoreSchema(log, engine, args, files.tables, "table")
+	restoreSchema(log, engine, args, files.views, "view")
+
+	var wg sync.WaitGroup
+
+	progress := newProgress(log, args, "restoring")
+	// the before_table hooks run once, before the first part of the table.
+	before := map[string]*sync.Once{}
+	for _, table := range files.datas {
+		tb, _ := dataTableName(table)
+		progress.addTotal(tb, uint64(files.sizes[table]), 0)
+		before[tb] = &sync.Once{}
+	}
+
+	for _, table := range files.datas {
+		wg.Add(1)
+		go func(engine *sqlEngine, table string) {
+			metricWorkers.Inc("restoring")
+			defer func() {
+				metricWorkers.Dec("restoring")
+				wg.Done()
+			}()
+			defer f.catch()
+			tb, _ := dataTableName(table)
+			// a failed hook cancels the run before the other parts of the table go on.
+			before[tb].Do(func() {
+				defer f.catch()
+				fireTableHooks(log, args, engine, nil, progress, common.HookBeforeTable, tb)
+			})
+			checkContext(args)
+			progress.setStatus(tb, statusRunning)
+			r := restoreData(log, args, table, engine)
+			progress.add(tb, uint64(r), 0)
+			if progress.partDone(tb) {
+				fireTableHooks(log, args, engine, nil, progress, common.HookAfterTable, tb)
+			}
+		}(engine, table)
+	}
+
+	progress.Start()
+	wg.Wait()
+	schemas.Wait()
+	f.check()
+	progress.Stop()
+	elapsedStr, elapsed := time.Since(t).String(), time.Since(t).Seconds()
+	log.Info("restoring.all.done.cost[%s].allbytes[%.2fMB].rate[%.2fMB/s]", elapsedStr, common.MB(args.Allbytes), common.MB(args.Allbytes)/elapsed)
+}
@@ -0,0 +1,48 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestLoadDataInfile(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT COLUMN_NAME", []string{"COLUMN_NAME"}, []driver.Value{[]byte("data")})
+	engine := &sqlEngine{db: db}
+	log := xlog.NewXLog(ioutil.Discard)
+	args := &common.Args{Context: context.Background(), Database: "db", Retries: 1, RetryBudget: 1}
+
+	data := []byte("\"id\",\"name\",\"data\"\n\"1\",\"a\",\"01AB\"\n")
+	if err := loadDataInfile(log, args, engine, "t.00001.csv", "t", "t.00001.csv", data); err != nil {
+		t.Fatal(err)
+	}
+	stmts := f.statements()
+	want := []string{
+		"SET FOREIGN_KEY_CHECKS=0",
+		"LOAD DATA LOCAL INFILE 'Reader::t.00001.csv' INTO TABLE `t` CHARACTER SET utf8mb4 FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '\"' ESCAPED BY '\\\\' LINES TERMINATED BY '\\n' IGNORE 1 LINES (`id`, `name`, @v2) SET `data` = UNHEX(@v2)",
+	}
+	if len(stmts) != 3 || !strings.HasPrefix(stmts[0][3:], "SELECT COLUMN_NAME") {
+		t.Fatalf("statements = %q", stmts)
+	}
+	// the session is set up on the connection of the load.
+	conn := stmts[1][:3]
+	for i, stmt := range want {
+		if stmts[i+1] != conn+stmt {
+			t.Errorf("statement %d = %q, want %q", i+1, stmts[i+1], conn+stmt)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	args.Context = ctx
+	if err := loadDataInfile(log, args, engine, "t.00002.tsv", "t", "t.00002.tsv", []byte("id\n1\n")); err == nil {
+		t.Error("loadDataInfile with a canceled context: want error")
+	}
+}
@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	"xorm.io/core"
+
+	"mysqldump/common"
+)
+
+var (
+	firstNames = []string{"James", "Mary", "John", "Linda", "Robert", "Susan", "Michael", "Karen", "David", "Lisa",
+		"Wei", "Fang", "Jun", "Li", "Yan", "Hao", "Ana", "Luis", "Sofia", "Omar"}
+	lastNames = []string{"Smith", "Johnson", "Brown", "Miller", "Davis", "Wilson", "Moore", "Taylor", "Clark", "Lewis",
+		"Wang", "Zhang", "Liu", "Chen", "Yang", "Zhao", "Garcia", "Lopez", "Silva", "Khan"}
+)
+
+// intTypes are the integer types, hashed to integers of the same bit length.
+var intTypes = map[string]bool{
+	core.TinyInt:   true,
+	core.SmallInt:  true,
+	core.MediumInt: true,
+	core.Int:       true,
+	core.Integer:   true,
+	core.BigInt:    true,
+}
+
+// maskColumn is a masked column of the table.
+type maskColumn struct {
+	index    int
+	col      *core.Column
+	rule     common.MaskRule
+	shuffled [][]byte
+}
+
+// masker used to mask the rows of a table, the values only depend on the salt and
+// the original value, so the same key is masked the same in every table.
+type masker struct {
+	salt    []byte
+	columns []*maskColumn
+	rows    int
+}
+
+// newMasker returns the masker of the table, nil if the table has no masking rules.
+func newMasker(engine *sqlEngine, args *common.Args, table *core.Table) *masker {
+	rules := args.Masking.Table(table.Name)
+	if len(rules) == 0 {
+		return nil
+	}
+	m := &masker{salt: []byte(args.Masking.Salt)}
+	index := map[string]int{}
+	for i, name := range table.ColumnsSeq() {
+		index[name] = i
+	}
+	for name, rule := range rules {
+		i, ok := index[name]
+		if !ok {
+			common.AssertNil(fmt.Errorf("mask column %s.%s not found", table.Name, name))
+		}
+		mc := &maskColumn{index: i, col: table.GetColumn(name), rule: rule}
+		if rule.Kind == common.MaskHash && mc.col.SQLType.IsNumeric() && !intTypes[mc.col.SQLType.Name] {
+			common.AssertNil(fmt.Errorf("mask column %s.%s: hash can't mask %s, only integer and text columns", table.Name, name, mc.col.SQLType.Name))
+		}
+		if rule.Kind == common.MaskShuffle {
+			mc.shuffled = m.shuffle(engine, args, table.Name, name)
+		}
+		m.columns = append(m.columns, mc)
+	}
+	return m
+}
+
+// shuffle reads the column of the dumped rows and returns its values in a random order seeded by the salt,
+// a filtered table only reads the rows of its filter.
+func (m *masker) shuffle(engine *sqlEngine, args *common.Args, table string, column string) [][]byte {
+	wheres := []string{""}
+	if f, ok := args.Filters[table]; ok {
+		wheres = f.Where
+	}
+	var values [][]byte
+	for _, where := range wheres {
+		query := fmt.Sprintf("SELECT `%s` FROM `%s`.`%s`", column, args.Database, table)
+		if where != "" {
+			query += " WHERE " + where
+		}
+		rows, err := engine.DB().Query(query)
+		common.AssertNil(err)
+		for rows.Next() {
+			var v []byte
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				common.AssertNil(err)
+			}
+			values = append(values, v)
+		}
+		common.AssertNil(rows.Err())
+		rows.Close()
+	}
+
+	seed := m.mac([]byte(table + "." + column))
+	r := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed))))
+	r.Shuffle(len(values), func(i, j int) { values[i], values[j] = values[j], values[i] })
+	return values
+}
+
+func (m *masker) mac(value []byte) []byte {
+	h := hmac.New(sha256.New, m.salt)
+	h.Write(value)
+	return h.Sum(nil)
+}
+
+func rawValue(d interface{}) []byte {
+	switch x := d.(type) {
+	case []byte:
+		return x
+	case string:
+		return []byte(x)
+	}
+	return []byte(fmt.Sprintf("%v", d))
+}
+
+// apply used to mask the values of the row in place.
+func (m *masker) apply(dest []interface{}) {
+	for _, c := range m.columns {
+		d := dest[c.index]
+		if c.rule.Kind == common.MaskShuffle {
+			if len(c.shuffled) == 0 {
+				dest[c.index] = nil
+			} else if v := c.shuffled[m.rows%len(c.shuffled)]; v != nil {
+				dest[c.index] = v
+			} else {
+				dest[c.index] = nil
+			}
+			continue
+		}
+		if d == nil {
+			continue
+		}
+		dest[c.index] = m.mask(c, rawValue(d))
+	}
+	m.rows++
+}
+
+func (m *masker) mask(c *maskColumn, v []byte) interface{} {
+	var out string
+	switch c.rule.Kind {
+	case common.MaskNull:
+		return nil
+	case common.MaskFixed:
+		return []byte(c.rule.Arg)
+	case common.MaskTruncate:
+		s := string(v)
+		for i := range s {
+			if utf8.RuneCountInString(s[:i]) == c.rule.Length {
+				return []byte(s[:i])
+			}
+		}
+		return v
+	case common.MaskHash:
+		if c.col.SQLType.IsNumeric() {
+			return []byte(m.hashInt(string(v)))
+		}
+		out = hex.EncodeToString(m.mac(v))
+	case common.MaskEmail:
+		out = m.email(v)
+	case common.MaskPhone:
+		out = m.phone(v)
+	case common.MaskName:
+		out = m.name(v)
+	}
+	if c.col.Length > 0 && utf8.RuneCountInString(out) > c.col.Length {
+		out = string([]rune(out)[:c.col.Length])
+	}
+	return []byte(out)
+}
+
+// hashInt returns the integer v permuted among the integers of its sign and bit length, so
+// the hashed keys stay unique and fit the column, whatever the integer type of the column.
+func (m *masker) hashInt(v string) string {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n < 0 {
+			// ^n maps [-2^63, -1] to [0, 2^63-1].
+			return strconv.FormatInt(^int64(m.permute(uint64(^n))), 10)
+		}
+		return strconv.FormatUint(m.permute(uint64(n)), 10)
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	common.AssertNil(err)
+	return strconv.FormatUint(m.permute(n), 10)
+}
+
+// permute returns the image of v by a permutation of [2^(b-1), 2^b) keyed by the salt, b the bit length of v.
+// It's a Feistel network on the even number of bits holding the range, walking the cycle back into it.
+func (m *masker) permute(v uint64) uint64 {
+	n := uint(bits.Len64(v))
+	if n <= 1 {
+		return v
+	}
+	n--
+	base := uint64(1) << n
+	half := (n + 1) / 2
+	mask := uint64(1)<<half - 1
+	x := v - base
+	for {
+		l, r := x>>half, x&mask
+		for round := byte(0); round < 4; round++ {
+			mac := m.mac([]byte{'i', byte(n), round, byte(r >> 56), byte(r >> 48), byte(r >> 40), byte(r >> 32), byte(r >> 24), byte(r >> 16), byte(r >> 8), byte(r)})
+			l, r = r, l^(binary.BigEndian.Uint64(mac)&mask)
+		}
+		x = l<<half | r
+		if x < base {
+			return base + x
+		}
+	}
+}
+
+// email keeps the length of the local part: alice@corp.com => k3x9a@example.com.
+func (m *masker) email(v []byte) string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	mac := m.mac(v)
+	n := strings.IndexByte(string(v), '@')
+	if n < 4 {
+		n = 4
+	}
+	if n > len(mac) {
+		n = len(mac)
+	}
+	local := make([]byte, n)
+	for i := range local {
+		local[i] = chars[int(mac[i])%len(chars)]
+	}
+	return string(local) + "@example.com"
+}
+
+// phone replaces the digits and keeps the rest: +1 (555) 010-2030 => +7 (281) 946-0518.
+func (m *masker) phone(v []byte) string {
+	mac := m.mac(v)
+	out := make([]byte, len(v))
+	for i, c := range v {
+		if c >= '0' && c <= '9' {
+			c = '0' + mac[i%len(mac)]%10
+		}
+		out[i] = c
+	}
+	return string(out)
+}
+
+// name returns a first name, and a last name if the value has more than one word.
+func (m *masker) name(v []byte) string {
+	mac := m.mac(v)
+	name := firstNames[int(mac[0])%len(firstNames)]
+	if strings.ContainsAny(strings.TrimSpace(string(v)), " \t") {
+		name += " " + lastNames[int(mac[1])%len(lastNames)]
+	}
+	return name
+}
+
+// rewind used to restart the shuffled values at the row n, the rows after it are read again.
+func (m *masker) rewind(n uint64) {
+	m.rows = int(n)
+}
+
+// maskEncoder masks the rows before they are encoded.
+type maskEncoder struct {
+	encoder
+	masker *masker
+}
+
+func (e *maskEncoder) row(dest []interface{}) string {
+	e.masker.apply(dest)
+	return e.encoder.row(dest)
+}
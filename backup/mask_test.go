@@ -0,0 +1,128 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestMaskPermute(t *testing.T) {
+	m := &masker{salt: []byte("secret")}
+	seen := map[uint64]uint64{}
+	for v := uint64(0); v < 1<<13; v++ {
+		got := m.permute(v)
+		if bits.Len64(got) != bits.Len64(v) {
+			t.Fatalf("permute(%d) = %d, want the same bit length", v, got)
+		}
+		if prev, ok := seen[got]; ok {
+			t.Fatalf("permute(%d) = permute(%d) = %d", v, prev, got)
+		}
+		seen[got] = v
+	}
+	for _, v := range []uint64{1<<31 - 1, 1 << 40, 1<<63 - 1, 1<<64 - 1} {
+		if got := m.permute(v); bits.Len64(got) != bits.Len64(v) {
+			t.Errorf("permute(%d) = %d, want the same bit length", v, got)
+		}
+	}
+	other := &masker{salt: []byte("other")}
+	same := 0
+	for v := uint64(1 << 20); v < 1<<20+100; v++ {
+		if m.permute(v) == other.permute(v) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 100 values are hashed the same with another salt", same)
+	}
+}
+
+func TestMaskHashInt(t *testing.T) {
+	m := &masker{salt: []byte("secret")}
+	tests := []struct {
+		v        string
+		min, max int64
+	}{
+		{"0", 0, 0},
+		{"1", 1, 1},
+		{"2147483647", 1 << 30, 1<<31 - 1},
+		{"-1", -1, -1},
+		{"-2147483648", -1 << 31, -1<<30 - 1},
+		{"-9223372036854775808", -1 << 63, -1<<62 - 1},
+	}
+	for _, tt := range tests {
+		got, err := strconv.ParseInt(m.hashInt(tt.v), 10, 64)
+		if err != nil {
+			t.Fatalf("hashInt(%s): %v", tt.v, err)
+		}
+		if got < tt.min || got > tt.max {
+			t.Errorf("hashInt(%s) = %d, want in [%d, %d]", tt.v, got, tt.min, tt.max)
+		}
+	}
+	if got, err := strconv.ParseUint(m.hashInt("18446744073709551615"), 10, 64); err != nil || got < 1<<63 {
+		t.Errorf("hashInt(2^64-1) = %d, %v, want a 64 bits unsigned value", got, err)
+	}
+	if m.hashInt("123456") != m.hashInt("123456") {
+		t.Error("hashInt is not deterministic")
+	}
+}
+
+// TestDumpTableMaskRetry checks the shuffled values restart with the rows read again after a retry.
+func TestDumpTableMaskRetry(t *testing.T) {
+	defer func(wait time.Duration) { retryWait = wait }(retryWait)
+	retryWait = time.Millisecond
+	dir, err := ioutil.TempDir("", "mask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mask.toml")
+	if err := ioutil.WriteFile(file, []byte("salt = \"secret\"\nt.name = \"shuffle\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	masking, err := common.ReadMasking(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT `name`", []string{"name"}, []driver.Value{[]byte("a")}, []driver.Value{[]byte("b")}, []driver.Value{[]byte("c")}, []driver.Value{[]byte("d")})
+	f.answer("SELECT /*backup*/", []string{"id", "name"},
+		[]driver.Value{int64(1), []byte("a")}, []driver.Value{int64(2), []byte("b")}, []driver.Value{int64(3), []byte("c")}, []driver.Value{int64(4), []byte("d")})
+
+	table := core.NewEmptyTable()
+	table.Name = "t"
+	table.StoreEngine = "InnoDB"
+	table.AddColumn(&core.Column{Name: "id", SQLType: core.SQLType{Name: core.Int}, IsPrimaryKey: true})
+	table.AddColumn(&core.Column{Name: "name", SQLType: core.SQLType{Name: core.Varchar}})
+	table.PrimaryKeys = []string{"id"}
+
+	args := &common.Args{Context: context.Background(), Database: "db", Format: formatSQL, StmtSize: 1000, ChunksizeInMB: 1, Masking: masking, Retries: 3, RetryBudget: 10}
+	engine := &sqlEngine{db: db, dialect: core.QueryDialect(core.MYSQL)}
+	log := xlog.NewXLog(ioutil.Discard)
+	dump := func() string {
+		var data string
+		dumpTable(log, engine, args, newProgress(log, args, "dumping"), table, func(fileNo int, rows uint64, chunk string) {
+			data += chunk
+		})
+		return data
+	}
+
+	want := dump()
+	f.failOnce("SELECT /*backup*/", 2, mysql.ErrInvalidConn)
+	if got := dump(); got != want {
+		t.Errorf("dump after a retry:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package backup
+
+import (
+	"sync/atomic"
+	"time"
+
+	"mysqldump/metrics"
+	xlog "mysqldump/xlog"
+)
+
+var (
+	registry      = metrics.NewRegistry()
+	metricChunks  = registry.NewCounter("mysqldump_chunks_total", "Chunk files written by the dumper or loaded by the loader.", "stage")
+	metricErrors  = registry.NewCounter("mysqldump_errors_total", "Failed queries.", "stage")
+	metricRetries = registry.NewCounter("mysqldump_retries_total", "Statements and chunks retried after a transient error.", "stage")
+	metricWorkers = registry.NewGauge("mysqldump_active_workers", "Workers currently dumping or restoring a table.", "stage")
+	metricQuery   = registry.NewHistogram("mysqldump_query_duration_seconds", "Latency of the queries.", metrics.DefBuckets, "stage")
+
+	// activeProgress holds the *Progress of the running dump or restore.
+	activeProgress atomic.Value
+)
+
+// ServeMetrics used to expose the metrics of the running dump or restore on addr in prometheus format.
+func ServeMetrics(log *xlog.Log, addr string) {
+	// the bytes and rows of a retried chunk are taken back, so they are gauges.
+	registry.NewGaugeFunc("mysqldump_bytes", "Bytes dumped or restored.", func() []metrics.Sample {
+		return statusSamples(func(s *ProgressStatus) float64 { return float64(s.Bytes) })
+	})
+	registry.NewGaugeFunc("mysqldump_rows", "Rows dumped or restored.", func() []metrics.Sample {
+		return statusSamples(func(s *ProgressStatus) float64 { return float64(s.Rows) })
+	})
+	registry.NewGaugeFunc("mysqldump_table_bytes", "Bytes dumped or restored per table.", func() []metrics.Sample {
+		return tableSamples(func(t *TableProgress) float64 { return float64(t.Bytes) })
+	}, "table")
+	registry.NewGaugeFunc("mysqldump_table_rows", "Rows dumped or restored per table.", func() []metrics.Sample {
+		return tableSamples(func(t *TableProgress) float64 { return float64(t.Rows) })
+	}, "table")
+	registry.NewGaugeFunc("mysqldump_table_done", "Whether the table is done(1) or not(0).", func() []metrics.Sample {
+		return tableSamples(func(t *TableProgress) float64 {
+			if t.Status == statusDone || t.Status == statusSkipped {
+				return 1
+			}
+			return 0
+		})
+	}, "table")
+	registry.NewGaugeFunc("mysqldump_progress_ratio", "Estimated progress of the run between 0 and 1.", func() []metrics.Sample {
+		return statusSamples(func(s *ProgressStatus) float64 { return s.Percent / 100 })
+	})
+
+	go func() {
+		log.Info("metrics.listen[%s]", addr)
+		if err := registry.Serve(addr); err != nil {
+			log.Error("metrics.listen[%s].error:%+v", addr, err)
+		}
+	}()
+}
+
+func statusSamples(value func(s *ProgressStatus) float64) []metrics.Sample {
+	p, ok := activeProgress.Load().(*Progress)
+	if !ok {
+		return nil
+	}
+	return []metrics.Sample{{Value: value(p.snapshot(statusRunning, false))}}
+}
+
+func tableSamples(value func(t *TableProgress) float64) []metrics.Sample {
+	p, ok := activeProgress.Load().(*Progress)
+	if !ok {
+		return nil
+	}
+	var samples []metrics.Sample
+	for _, t := range p.snapshot(statusRunning, true).Tables {
+		samples = append(samples, metrics.Sample{Labels: []string{t.Name}, Value: value(t)})
+	}
+	return samples
+}
+
+// observeQuery used to record the latency and the failure of a query.
+func observeQuery(stage string, start time.Time, err error) {
+	metricQuery.Observe(time.Since(start).Seconds(), stage)
+	if err != nil {
+		metricErrors.Inc(stage)
+	}
+}
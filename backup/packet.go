@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"strconv"
+	"strings"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// packetMargin is left out of max_allowed_packet for the header of the packet.
+const packetMargin = 1024
+
+// maxAllowedPacket returns the max_allowed_packet of the server, 0 if it can't be read.
+func maxAllowedPacket(log *xlog.Log, engine *sqlEngine, stage string) int {
+	qr, err := engine.QueryString("SELECT @@max_allowed_packet AS packet")
+	if err != nil || len(qr) == 0 {
+		log.Warning("%s.max_allowed_packet.error:%v", stage, err)
+		return 0
+	}
+	n, _ := strconv.Atoi(qr[0]["packet"])
+	return n
+}
+
+// capStmtSize used to keep the INSERT statements of the dumper below the max_allowed_packet of
+// the server they go to.
+func capStmtSize(log *xlog.Log, args *common.Args, engine *sqlEngine, stage string) {
+	args.MaxAllowedPacket = maxAllowedPacket(log, engine, stage)
+	if limit := stmtLimit(args); limit > 0 && args.StmtSize > limit {
+		log.Info("%s.stmt-size[%d].capped.to.max_allowed_packet[%d]", stage, args.StmtSize, args.MaxAllowedPacket)
+		args.StmtSize = limit
+	}
+}
+
+// stmtLimit returns the max size of a statement, 0 if there is no limit.
+func stmtLimit(args *common.Args) int {
+	if args.MaxAllowedPacket <= packetMargin {
+		return 0
+	}
+	return args.MaxAllowedPacket - packetMargin
+}
+
+// splitStatement used to split the INSERT beyond the limit of the server into smaller ones.
+func splitStatement(log *xlog.Log, args *common.Args, table string, stmt string) []string {
+	limit := stmtLimit(args)
+	stmts := splitInsert(stmt, limit)
+	if len(stmts) > 1 {
+		log.Info("restoring.table[%s].statement[%d].split.into[%d].for.max_allowed_packet[%d]", table, len(stmt), len(stmts), args.MaxAllowedPacket)
+	}
+	for _, s := range stmts {
+		if limit > 0 && len(s) > limit {
+			log.Warning("restoring.table[%s].statement[%d].exceeds.max_allowed_packet[%d], a row can't fit", table, len(s), args.MaxAllowedPacket)
+		}
+	}
+	return stmts
+}
+
+// splitInsert used to split the multi-row INSERT/REPLACE into statements of at most limit bytes,
+// keeping the head and the tail(ON DUPLICATE KEY UPDATE...) in each of them.
+// The statement is returned as is if it fits or isn't a multi-row INSERT.
+func splitInsert(stmt string, limit int) []string {
+	if limit <= 0 || len(stmt) <= limit {
+		return []string{stmt}
+	}
+	head, tuples, tail, ok := insertTuples(stmt)
+	if !ok || len(tuples) < 2 {
+		return []string{stmt}
+	}
+
+	var stmts []string
+	var group []string
+	size := len(head) + len(tail)
+	for _, t := range tuples {
+		// the tuples after the first one come with a ",\n".
+		if len(group) > 0 && size+2+len(t) > limit {
+			stmts = append(stmts, head+strings.Join(group, ",\n")+tail)
+			group, size = group[:0], len(head)+len(tail)
+		}
+		if len(group) > 0 {
+			size += 2
+		}
+		group = append(group, t)
+		size += len(t)
+	}
+	return append(stmts, head+strings.Join(group, ",\n")+tail)
+}
+
+// insertTuples used to split 'INSERT INTO t(a, b) VALUES (1, 'x'),(2, 'y') ON DUPLICATE...'
+// into its head up to VALUES, the row tuples and the tail.
+func insertTuples(stmt string) (head string, tuples []string, tail string, ok bool) {
+	i := 0
+	for {
+		i = skipQuoted(stmt, i)
+		if i >= len(stmt) {
+			return "", nil, "", false
+		}
+		if isWordAt(stmt, i, "VALUES") || isWordAt(stmt, i, "VALUE") {
+			break
+		}
+		i++
+	}
+	for i < len(stmt) && isIdentByte(stmt[i]) {
+		i++
+	}
+	head = stmt[:i] + "\n"
+
+	for {
+		for i < len(stmt) && isSpace(stmt[i]) {
+			i++
+		}
+		if i >= len(stmt) || stmt[i] != '(' {
+			return "", nil, "", false
+		}
+		start, depth := i, 0
+		for i < len(stmt) {
+			i = skipQuoted(stmt, i)
+			if i >= len(stmt) {
+				return "", nil, "", false
+			}
+			if stmt[i] == '(' {
+				depth++
+			} else if stmt[i] == ')' {
+				depth--
+			}
+			i++
+			if depth == 0 {
+				break
+			}
+		}
+		tuples = append(tuples, stmt[start:i])
+
+		j := i
+		for j < len(stmt) && isSpace(stmt[j]) {
+			j++
+		}
+		if j < len(stmt) && stmt[j] == ',' {
+			i = j + 1
+			continue
+		}
+		return head, tuples, stmt[i:], true
+	}
+}
+
+// skipQuoted returns the position of stmt[i:] out of the strings and the quoted identifiers.
+func skipQuoted(stmt string, i int) int {
+	for i < len(stmt) {
+		q := stmt[i]
+		if q != '\'' && q != '"' && q != '`' {
+			return i
+		}
+		i++
+		for i < len(stmt) && stmt[i] != q {
+			if stmt[i] == '\\' && q != '`' {
+				i++
+			}
+			i++
+		}
+		// past the closing quote, a doubled quote opens the string again.
+		i++
+	}
+	return i
+}
+
+func isWordAt(s string, i int, word string) bool {
+	if i+len(word) > len(s) || !strings.EqualFold(s[i:i+len(word)], word) {
+		return false
+	}
+	if i > 0 && isIdentByte(s[i-1]) {
+		return false
+	}
+	return i+len(word) == len(s) || !isIdentByte(s[i+len(word)])
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t'
+}
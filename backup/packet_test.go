@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitInsert(t *testing.T) {
+	tests := []struct {
+		name  string
+		stmt  string
+		limit int
+		want  []string
+	}{
+		{"fits", "INSERT INTO `t` VALUES (1),(2)", 100, []string{"INSERT INTO `t` VALUES (1),(2)"}},
+		{"no limit", "INSERT INTO `t` VALUES (1),(2)", 0, []string{"INSERT INTO `t` VALUES (1),(2)"}},
+		{
+			"one row each",
+			"INSERT INTO `t` VALUES (1,'a'),(2,'b'),(3,'c')", 30,
+			[]string{"INSERT INTO `t` VALUES\n(1,'a')", "INSERT INTO `t` VALUES\n(2,'b')", "INSERT INTO `t` VALUES\n(3,'c')"},
+		},
+		{
+			"rows grouped up to the limit",
+			"INSERT INTO `t` VALUES\n(1),\n(2),\n(3),\n(4),\n(5)", 36,
+			[]string{"INSERT INTO `t` VALUES\n(1),\n(2),\n(3)", "INSERT INTO `t` VALUES\n(4),\n(5)"},
+		},
+		{
+			"quotes and parentheses in the values",
+			"REPLACE INTO `t` (`values`) VALUES ('),(\\''),(\"x)\"),(CONCAT('a', ')'))", 40,
+			[]string{"REPLACE INTO `t` (`values`) VALUES\n('),(\\'')", "REPLACE INTO `t` (`values`) VALUES\n(\"x)\")", "REPLACE INTO `t` (`values`) VALUES\n(CONCAT('a', ')'))"},
+		},
+		{
+			"the tail goes to each statement",
+			"INSERT INTO t(a) VALUE (1), (2) ON DUPLICATE KEY UPDATE a=VALUES(a)", 60,
+			[]string{"INSERT INTO t(a) VALUE\n(1) ON DUPLICATE KEY UPDATE a=VALUES(a)", "INSERT INTO t(a) VALUE\n(2) ON DUPLICATE KEY UPDATE a=VALUES(a)"},
+		},
+		{
+			"a row beyond the limit is kept whole",
+			"INSERT INTO t VALUES ('a long row beyond the limit'),(2)", 30,
+			[]string{"INSERT INTO t VALUES\n('a long row beyond the limit')", "INSERT INTO t VALUES\n(2)"},
+		},
+		{"one row", "INSERT INTO t VALUES ('a long row beyond the limit')", 10, []string{"INSERT INTO t VALUES ('a long row beyond the limit')"}},
+		{"not a multi-row insert", "INSERT INTO t SELECT * FROM s WHERE a IN ('VALUES (1),(2)')", 10, []string{"INSERT INTO t SELECT * FROM s WHERE a IN ('VALUES (1),(2)')"}},
+		{"a column named like values", "INSERT INTO t (my_values) SELECT 1", 10, []string{"INSERT INTO t (my_values) SELECT 1"}},
+		{"unterminated", "INSERT INTO t VALUES (1),('2", 10, []string{"INSERT INTO t VALUES (1),('2"}},
+	}
+	for _, tt := range tests {
+		got := splitInsert(tt.stmt, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSplitInsertSizes checks the split statements keep every row once and fit the limit.
+func TestSplitInsertSizes(t *testing.T) {
+	var rows []string
+	for i := 0; i < 500; i++ {
+		rows = append(rows, "("+strings.Repeat("x", i%37)+")")
+	}
+	stmt := "INSERT INTO `t` VALUES " + strings.Join(rows, ",")
+	for _, limit := range []int{64, 100, 1000, 4096} {
+		var got []string
+		for _, s := range splitInsert(stmt, limit) {
+			if len(s) > limit {
+				t.Errorf("limit %d: statement of %d bytes", limit, len(s))
+			}
+			if !strings.HasPrefix(s, "INSERT INTO `t` VALUES\n") {
+				t.Fatalf("limit %d: bad statement %q", limit, s)
+			}
+			got = append(got, strings.Split(strings.TrimPrefix(s, "INSERT INTO `t` VALUES\n"), ",\n")...)
+		}
+		if !reflect.DeepEqual(got, rows) {
+			t.Errorf("limit %d: the rows changed", limit)
+		}
+	}
+}
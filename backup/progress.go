@@ -0,0 +1,261 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// Table status.
+const (
+	statusPending = common.StatusPending
+	statusRunning = common.StatusRunning
+	statusSkipped = common.StatusSkipped
+	statusDone    = common.StatusDone
+)
+
+// TableProgress and ProgressStatus are passed to the progress callbacks.
+type (
+	TableProgress  = common.TableProgress
+	ProgressStatus = common.ProgressStatus
+)
+
+// Progress used to track the dump/restore progress of all tables.
+type Progress struct {
+	mu     sync.Mutex
+	log    *xlog.Log
+	args   *common.Args
+	stage  string
+	start  time.Time
+	tables map[string]*TableProgress
+
+	// byRows used to estimate by rows instead of bytes.
+	byRows bool
+	tick   *time.Ticker
+}
+
+func newProgress(log *xlog.Log, args *common.Args, stage string) *Progress {
+	p := &Progress{
+		log:    log,
+		args:   args,
+		stage:  stage,
+		start:  time.Now(),
+		tables: make(map[string]*TableProgress),
+	}
+	activeProgress.Store(p)
+	return p
+}
+
+func (p *Progress) table(name string) *TableProgress {
+	t, ok := p.tables[name]
+	if !ok {
+		t = &TableProgress{Name: name, Status: statusPending}
+		p.tables[name] = t
+	}
+	return t
+}
+
+// tableStatus returns a copy of the progress of the table.
+func (p *Progress) tableStatus(name string) TableProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return *p.table(name)
+}
+
+// addTotal used to add the expected bytes/rows of the table.
+func (p *Progress) addTotal(name string, bytes, rows uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.table(name)
+	t.TotalBytes += bytes
+	t.TotalRows += rows
+	t.Parts++
+}
+
+func (p *Progress) setStatus(name, status string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notify(p.table(name), status)
+}
+
+// notify used to set the status of the table and call OnTable if it changed, p.mu is held.
+func (p *Progress) notify(t *TableProgress, status string) {
+	changed := t.Status != status
+	t.Status = status
+	if changed && p.args.OnTable != nil {
+		p.args.OnTable(p.stage, *t)
+	}
+}
+
+// partDone used to mark one part of the table restored, it returns true on the last part.
+func (p *Progress) partDone(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t := p.table(name)
+	t.PartsDone++
+	if t.PartsDone >= t.Parts {
+		p.notify(t, statusDone)
+		return true
+	}
+	p.notify(t, statusRunning)
+	return false
+}
+
+func (p *Progress) add(name string, bytes, rows uint64) {
+	atomic.AddUint64(&p.args.Allbytes, bytes)
+	atomic.AddUint64(&p.args.Allrows, rows)
+	p.mu.Lock()
+	t := p.table(name)
+	t.Bytes += bytes
+	t.Rows += rows
+	p.mu.Unlock()
+}
+
+// undo used to take back the bytes and rows of the table which are read again.
+func (p *Progress) undo(name string, bytes, rows uint64) {
+	atomic.AddUint64(&p.args.Allbytes, -bytes)
+	atomic.AddUint64(&p.args.Allrows, -rows)
+	p.mu.Lock()
+	t := p.table(name)
+	t.Bytes -= bytes
+	t.Rows -= rows
+	p.mu.Unlock()
+}
+
+// estimateDump used to fetch the expected size of tables from information_schema.
+func (p *Progress) estimateDump(engine *sqlEngine) {
+	qr, err := engine.QueryString(fmt.Sprintf("SELECT TABLE_NAME, IFNULL(TABLE_ROWS, 0) AS TABLE_ROWS, IFNULL(DATA_LENGTH, 0) AS DATA_LENGTH FROM information_schema.TABLES WHERE TABLE_SCHEMA = '%s' AND TABLE_TYPE = 'BASE TABLE'", p.args.Database))
+	if err != nil {
+		p.log.Warning("progress.estimate.error:%+v", err)
+		return
+	}
+	for _, t := range qr {
+		rows, _ := strconv.ParseUint(t["TABLE_ROWS"], 10, 64)
+		bytes, _ := strconv.ParseUint(t["DATA_LENGTH"], 10, 64)
+		if f := p.args.Filters[t["TABLE_NAME"]]; f != nil && f.Rows > 0 && rows > 0 {
+			bytes = bytes * minUint64(f.Rows, rows) / rows
+			rows = f.Rows
+		}
+		p.addTotal(t["TABLE_NAME"], bytes, rows)
+	}
+	p.byRows = true
+}
+
+func (p *Progress) snapshot(state string, withTables bool) *ProgressStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := &ProgressStatus{
+		Stage:     p.stage,
+		State:     state,
+		Database:  p.args.Database,
+		StartTime: p.start,
+		Bytes:     atomic.LoadUint64(&p.args.Allbytes),
+		Rows:      atomic.LoadUint64(&p.args.Allrows),
+	}
+	s.ElapsedSec = time.Since(p.start).Seconds()
+
+	var done, total uint64
+	for _, t := range p.tables {
+		if t.Status == statusSkipped {
+			continue
+		}
+		s.TotalBytes += t.TotalBytes
+		s.TotalRows += t.TotalRows
+		// table_rows is an estimate, so never count more than the real rows of a finished table.
+		if p.byRows {
+			total += t.TotalRows
+			done += minUint64(t.Rows, t.TotalRows)
+		} else {
+			total += t.TotalBytes
+			done += minUint64(t.Bytes, t.TotalBytes)
+		}
+		if withTables {
+			c := *t
+			s.Tables = append(s.Tables, &c)
+		}
+	}
+	sort.Slice(s.Tables, func(i, j int) bool { return s.Tables[i].Name < s.Tables[j].Name })
+
+	if s.ElapsedSec > 0 {
+		s.RateMB = common.MB(s.Bytes) / s.ElapsedSec
+	}
+	switch {
+	case state == statusDone:
+		s.Percent = 100
+	case total > 0:
+		s.Percent = float64(done) * 100 / float64(total)
+		if s.Percent > 0 {
+			s.EtaSec = s.ElapsedSec*100/s.Percent - s.ElapsedSec
+		}
+	}
+	return s
+}
+
+func (p *Progress) report(state string) {
+	s := p.snapshot(state, p.args.ProgressFile != "" || p.args.OnProgress != nil)
+	if p.args.OnProgress != nil {
+		p.args.OnProgress(s)
+	}
+	if p.args.ProgressFile != "" {
+		data, err := json.MarshalIndent(s, "", "  ")
+		common.AssertNil(err)
+		if err := common.WriteFile(p.args.ProgressFile, common.BytesToString(data)); err != nil {
+			p.log.Warning("progress.write.file[%s].error:%+v", p.args.ProgressFile, err)
+		}
+	}
+	if p.args.ProgressFormat == "json" {
+		line := *s
+		line.Tables = nil
+		data, err := json.Marshal(&line)
+		common.AssertNil(err)
+		fmt.Fprintln(p.args.ProgressOut, common.BytesToString(data))
+		return
+	}
+	if state != statusRunning {
+		return
+	}
+	eta := "unknown"
+	if s.EtaSec > 0 {
+		eta = (time.Duration(s.EtaSec) * time.Second).String()
+	}
+	p.log.Info("%s.allbytes[%.2fMB].allrows[%v].time[%.2fsec].rates[%.2fMB/sec].progress[%.1f%%].eta[%s]...", p.stage, common.MB(s.Bytes), s.Rows, s.ElapsedSec, s.RateMB, s.Percent, eta)
+}
+
+// Start used to report the progress every IntervalMs.
+func (p *Progress) Start() {
+	p.tick = time.NewTicker(time.Millisecond * time.Duration(p.args.IntervalMs))
+	go func(tick *time.Ticker) {
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				p.report(statusRunning)
+			case <-p.args.Context.Done():
+				return
+			}
+		}
+	}(p.tick)
+}
+
+// Stop used to stop the ticker and write the final status.
+func (p *Progress) Stop() {
+	if p.tick != nil {
+		p.tick.Stop()
+	}
+	p.report(statusDone)
+}
+
+func minUint64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
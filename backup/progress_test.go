@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestProgressJSONLines(t *testing.T) {
+	var logs, out bytes.Buffer
+	args := &common.Args{Database: "db", ProgressFormat: "json", ProgressOut: &out}
+	p := newProgress(xlog.NewXLog(&logs), args, "dumping")
+	p.addTotal("t1", 100, 0)
+	p.addTotal("t2", 300, 0)
+	p.setStatus("t1", statusRunning)
+	p.add("t1", 100, 10)
+	p.report(statusRunning)
+	p.add("t2", 100, 10)
+	p.report(statusRunning)
+	p.report(statusDone)
+
+	var lines []ProgressStatus
+	sc := bufio.NewScanner(&out)
+	for sc.Scan() {
+		var s ProgressStatus
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			t.Fatalf("progress line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, s)
+	}
+	if len(lines) != 3 {
+		t.Fatalf("got %d progress lines, want 3", len(lines))
+	}
+	if lines[0].Percent != 25 || lines[1].Percent != 50 || lines[2].Percent != 100 {
+		t.Errorf("percents = %v, %v, %v, want 25, 50, 100", lines[0].Percent, lines[1].Percent, lines[2].Percent)
+	}
+	if lines[1].Bytes != 200 || lines[1].Rows != 20 || lines[1].Tables != nil || lines[2].State != statusDone {
+		t.Errorf("progress lines = %+v", lines)
+	}
+}
+
+func TestProgressSkipped(t *testing.T) {
+	args := &common.Args{}
+	p := newProgress(xlog.NewXLog(&bytes.Buffer{}), args, "restoring")
+	p.addTotal("t1", 100, 0)
+	p.addTotal("t2", 100, 0)
+	p.setStatus("t2", statusSkipped)
+	p.add("t1", 50, 0)
+	s := p.snapshot(statusRunning, true)
+	if s.Percent != 50 || s.TotalBytes != 100 || len(s.Tables) != 1 || s.Tables[0].Name != "t1" {
+		t.Errorf("snapshot = %+v", s)
+	}
+}
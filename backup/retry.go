@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// Backoff of the retries, doubled from retryWait up to retryMaxWait.
+var (
+	retryWait    = 200 * time.Millisecond
+	retryMaxWait = 10 * time.Second
+)
+
+// retryableErrors are the transient server errors: lock wait timeout, deadlock,
+// server has gone away and lost connection.
+var retryableErrors = map[uint16]bool{1205: true, 1213: true, 2006: true, 2013: true}
+
+// writeRetryableErrors are the transient server errors rolling the statement back: lock wait timeout
+// and deadlock.
+var writeRetryableErrors = map[uint16]bool{1205: true, 1213: true}
+
+// unsentError is an error raised before the statement was sent to the server.
+type unsentError struct {
+	err error
+}
+
+func (e *unsentError) Error() string { return e.err.Error() }
+func (e *unsentError) Unwrap() error { return e.err }
+
+// retryable returns whether err, or an error it wraps, is transient: the statement may succeed if it runs again.
+func retryable(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		switch err {
+		case driver.ErrBadConn, mysql.ErrInvalidConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF:
+			return true
+		}
+		switch e := err.(type) {
+		case *mysql.MySQLError:
+			return retryableErrors[e.Number]
+		case net.Error:
+			return true
+		}
+	}
+	return false
+}
+
+// retryableWrite returns whether the write failed with err is transient and left nothing applied on
+// the server. A lost connection may come after the server applied the statement, running it again
+// would duplicate the rows, so only the errors raised before it was sent are retried.
+func retryableWrite(err error) bool {
+	var unsent *unsentError
+	if errors.As(err, &unsent) {
+		return retryable(unsent.err)
+	}
+	if errors.Is(err, driver.ErrBadConn) {
+		// the driver returns it only if nothing was sent.
+		return true
+	}
+	var e *mysql.MySQLError
+	return errors.As(err, &e) && writeRetryableErrors[e.Number]
+}
+
+// backoff returns the wait before the retry, with a jitter of up to a half.
+func backoff(retry int) time.Duration {
+	wait := retryWait
+	for i := 1; i < retry && wait < retryMaxWait; i++ {
+		wait *= 2
+	}
+	if wait > retryMaxWait {
+		wait = retryMaxWait
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retry used to call fn until it returns nil or a fatal error, a transient error is retried
+// after the backoff up to args.Retries times, within the retry budget of the run.
+// It returns the last error of fn.
+func retry(log *xlog.Log, args *common.Args, stage string, what string, fn func() error) error {
+	return retryIf(log, args, stage, what, retryable, fn)
+}
+
+// retryWrite used to call fn like retry, only the errors of retryableWrite are retried.
+func retryWrite(log *xlog.Log, args *common.Args, stage string, what string, fn func() error) error {
+	return retryIf(log, args, stage, what, retryableWrite, fn)
+}
+
+func retryIf(log *xlog.Log, args *common.Args, stage string, what string, transient func(error) bool, fn func() error) error {
+	for n := 1; ; n++ {
+		err := fn()
+		if err == nil || !transient(err) || n > args.Retries || args.Context.Err() != nil {
+			return err
+		}
+		if atomic.AddInt64(&args.RetryBudget, -1) < 0 {
+			log.Error("%s.error:%v, retry.budget.exhausted", what, err)
+			return err
+		}
+		wait := backoff(n)
+		log.Warning("%s.error:%v, retry[%d/%d].after[%v]", what, err, n, args.Retries, wait)
+		metricRetries.Inc(stage)
+		select {
+		case <-time.After(wait):
+		case <-args.Context.Done():
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err   error
+		read  bool
+		write bool
+	}{
+		{nil, false, false},
+		{errors.New("syntax"), false, false},
+		{&mysql.MySQLError{Number: 1062}, false, false},
+		{&mysql.MySQLError{Number: 1205}, true, true},
+		{&mysql.MySQLError{Number: 1213}, true, true},
+		{fmt.Errorf("batch: %w", &mysql.MySQLError{Number: 1213}), true, true},
+		// the server may have applied the statement before the connection was lost.
+		{&mysql.MySQLError{Number: 2013}, true, false},
+		{&mysql.MySQLError{Number: 2006}, true, false},
+		{mysql.ErrInvalidConn, true, false},
+		{io.EOF, true, false},
+		{driver.ErrBadConn, true, true},
+		{&unsentError{mysql.ErrInvalidConn}, true, true},
+		{&unsentError{fmt.Errorf("SET x: %w", &mysql.MySQLError{Number: 2013})}, true, true},
+		{&unsentError{&mysql.MySQLError{Number: 1064}}, false, false},
+	}
+	for _, tt := range tests {
+		if got := retryable(tt.err); got != tt.read {
+			t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.read)
+		}
+		if got := retryableWrite(tt.err); got != tt.write {
+			t.Errorf("retryableWrite(%v) = %v, want %v", tt.err, got, tt.write)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for retry := 1; retry < 12; retry++ {
+		wait := retryWait << uint(retry-1)
+		if wait > retryMaxWait {
+			wait = retryMaxWait
+		}
+		for i := 0; i < 20; i++ {
+			if got := backoff(retry); got < wait/2 || got > wait {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v]", retry, got, wait/2, wait)
+			}
+		}
+	}
+}
+
+func TestRetryIf(t *testing.T) {
+	defer func(wait time.Duration) { retryWait = wait }(retryWait)
+	retryWait = time.Millisecond
+	log := xlog.NewXLog(ioutil.Discard)
+
+	tests := []struct {
+		name    string
+		retries int
+		budget  int64
+		errs    []error
+		write   bool
+		calls   int
+		err     bool
+	}{
+		{"ok", 3, 10, nil, false, 1, false},
+		{"transient then ok", 3, 10, []error{mysql.ErrInvalidConn}, false, 2, false},
+		{"fatal", 3, 10, []error{&mysql.MySQLError{Number: 1064}}, false, 1, true},
+		{"retries exhausted", 2, 10, []error{io.EOF, io.EOF, io.EOF, io.EOF}, false, 3, true},
+		{"budget exhausted", 5, 1, []error{io.EOF, io.EOF, io.EOF}, false, 2, true},
+		{"write lost connection", 3, 10, []error{mysql.ErrInvalidConn}, true, 1, true},
+		{"write deadlock", 3, 10, []error{&mysql.MySQLError{Number: 1213}}, true, 2, false},
+	}
+	for _, tt := range tests {
+		args := &common.Args{Context: context.Background(), Retries: tt.retries, RetryBudget: tt.budget}
+		calls := 0
+		fn := func() error {
+			calls++
+			if calls <= len(tt.errs) {
+				return tt.errs[calls-1]
+			}
+			return nil
+		}
+		var err error
+		if tt.write {
+			err = retryWrite(log, args, "restoring", tt.name, fn)
+		} else {
+			err = retry(log, args, "dumping", tt.name, fn)
+		}
+		if (err != nil) != tt.err || calls != tt.calls {
+			t.Errorf("%s: err = %v, calls = %d, want error %v, calls %d", tt.name, err, calls, tt.err, tt.calls)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+const streamHeader = `-- mysqldump %s
+--
+-- Database: %s
+-- ------------------------------------------------------
+
+/*!40101 SET @OLD_CHARACTER_SET_CLIENT=@@CHARACTER_SET_CLIENT */;
+/*!40101 SET NAMES utf8mb4 */;
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40014 SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+
+CREATE DATABASE /*!32312 IF NOT EXISTS*/ ` + "`%s`" + `;
+
+USE ` + "`%s`" + `;
+`
+
+const streamFooter = `
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
+
+-- Dump completed on %s
+`
+
+// streamChunk is one data chunk of a table.
+type streamChunk struct {
+	rows uint64
+	data string
+}
+
+// dumpStream used to dump the database as one ordered sql stream to w,
+// tables are dumped in parallel but written in order.
+func dumpStream(log *xlog.Log, args *common.Args, engine *sqlEngine, w io.Writer, f *failure) {
+	t := time.Now()
+	out := bufio.NewWriterSize(w, 1<<20)
+	write := func(format string, v ...interface{}) {
+		_, err := fmt.Fprintf(out, format, v...)
+		common.AssertNil(err)
+	}
+
+	tables, err := engine.DBMetas()
+	common.AssertNil(err)
+	progress := newProgress(log, args, "dumping")
+	progress.estimateDump(engine)
+	capStmtSize(log, args, engine, "dumping")
+
+	write(streamHeader, Version, args.Database, args.Database, args.Database)
+
+	// every table has a bounded channel, the workers take the threads in table order,
+	// so the table being written always holds one and the others wait for it.
+	chunks := make([]chan streamChunk, len(tables))
+	for i := range tables {
+		chunks[i] = make(chan streamChunk, 1)
+	}
+	sem := make(chan struct{}, args.Threads)
+	go func() {
+		defer f.catch()
+		for i, table := range tables {
+			if !dumpData(args, table.Name) {
+				progress.setStatus(table.Name, statusSkipped)
+				close(chunks[i])
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-args.Context.Done():
+				close(chunks[i])
+				continue
+			}
+			go func(table *core.Table, ch chan streamChunk) {
+				defer func() {
+					<-sem
+					close(ch)
+				}()
+				defer f.catch()
+				fireTableHooks(log, args, engine, nil, progress, common.HookBeforeTable, table.Name)
+				progress.setStatus(table.Name, statusRunning)
+				dumpTable(log, engine, args, progress, table, func(fileNo int, rows uint64, data string) {
+					select {
+					case ch <- streamChunk{rows: rows, data: data}:
+					case <-args.Context.Done():
+						checkContext(args)
+					}
+				})
+				progress.setStatus(table.Name, statusDone)
+				fireTableHooks(log, args, engine, nil, progress, common.HookAfterTable, table.Name)
+			}(table, chunks[i])
+		}
+	}()
+
+	progress.Start()
+	for i, table := range tables {
+		if !args.NoSchema {
+			write("\n--\n-- Table structure for table `%s`\n--\n\nDROP TABLE IF EXISTS `%s`;\n%s;\n", table.Name, table.Name, showCreate(engine, args, "table", table.Name))
+		}
+		if dumpData(args, table.Name) {
+			write("\n--\n-- Dumping data for table `%s`\n--\n\n", table.Name)
+		}
+		for c := range chunks[i] {
+			write("%s", c.data)
+		}
+		f.check()
+	}
+
+	for _, routineType := range routineTypes(args) {
+		kind := strings.ToLower(routineType)
+		for _, name := range listRoutines(engine, args, routineType) {
+			write("\n--\n-- Dumping %s `%s`\n--\n\nDROP %s IF EXISTS `%s`;\nDELIMITER ;;\n%s ;;\nDELIMITER ;\n", kind, name, routineType, name, showCreate(engine, args, kind, name))
+		}
+	}
+	if !args.NoViews {
+		for _, name := range listViews(engine, args) {
+			write("\n--\n-- View `%s`\n--\n\nDROP VIEW IF EXISTS `%s`;\n%s;\n", name, name, showCreate(engine, args, "view", name))
+		}
+	}
+	write(streamFooter, time.Now().Format("2006-01-02 15:04:05"))
+	common.AssertNil(out.Flush())
+
+	progress.Stop()
+	elapsedStr, elapsed := time.Since(t).String(), time.Since(t).Seconds()
+	log.Info("dumping.all.done.cost[%s].allrows[%v].allbytes[%v].rate[%.2fMB/s]", elapsedStr, args.Allrows, args.Allbytes, common.MB(args.Allbytes)/elapsed)
+}
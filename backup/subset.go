@@ -0,0 +1,281 @@
+package backup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// subsetBatch is the number of keys in one IN list.
+const subsetBatch = 1000
+
+var samplePercent = regexp.MustCompile(`^\s*(\d+(?:\.\d+)?)\s*%\s*$`)
+
+// SubsetRoot is a root table of the subset, with a WHERE condition or a sample percentage.
+type SubsetRoot struct {
+	table   string
+	where   string
+	percent float64
+}
+
+// ParseSubset used to parse the roots separated by ';', like "customers:id = 42;products:5%".
+func ParseSubset(spec string) ([]SubsetRoot, error) {
+	var roots []SubsetRoot
+	for _, s := range strings.Split(spec, ";") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("subset root %q: expect 'table:condition' or 'table:percent%%'", s)
+		}
+		root := SubsetRoot{table: strings.TrimSpace(kv[0])}
+		if m := samplePercent.FindStringSubmatch(kv[1]); m != nil {
+			root.percent, _ = strconv.ParseFloat(m[1], 64)
+			if root.percent <= 0 || root.percent > 100 {
+				return nil, fmt.Errorf("subset root %q: percent must be in (0, 100]", s)
+			}
+		} else {
+			root.where = strings.TrimSpace(kv[1])
+		}
+		roots = append(roots, root)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("subset has no root table")
+	}
+	return roots, nil
+}
+
+// foreignKey is a foreign key of the database, table(cols) references parent(parentCols).
+type foreignKey struct {
+	table      string
+	cols       []string
+	parent     string
+	parentCols []string
+}
+
+func listForeignKeys(engine *sqlEngine, args *common.Args) []*foreignKey {
+	qr, err := engine.QueryString(fmt.Sprintf("SELECT TABLE_NAME, CONSTRAINT_NAME, COLUMN_NAME, REFERENCED_TABLE_NAME, REFERENCED_COLUMN_NAME FROM information_schema.KEY_COLUMN_USAGE WHERE TABLE_SCHEMA = '%s' AND REFERENCED_TABLE_SCHEMA = '%s' ORDER BY TABLE_NAME, CONSTRAINT_NAME, ORDINAL_POSITION", args.Database, args.Database))
+	common.AssertNil(err)
+
+	var fks []*foreignKey
+	index := map[string]*foreignKey{}
+	for _, r := range qr {
+		key := r["TABLE_NAME"] + "\x00" + r["CONSTRAINT_NAME"]
+		fk, ok := index[key]
+		if !ok {
+			fk = &foreignKey{table: r["TABLE_NAME"], parent: r["REFERENCED_TABLE_NAME"]}
+			index[key] = fk
+			fks = append(fks, fk)
+		}
+		fk.cols = append(fk.cols, r["COLUMN_NAME"])
+		fk.parentCols = append(fk.parentCols, r["REFERENCED_COLUMN_NAME"])
+	}
+	return fks
+}
+
+// subsetStep is a group of new rows of a table to follow.
+type subsetStep struct {
+	table string
+	keys  [][]string
+	down  bool
+}
+
+// subset used to collect the primary keys of the rows in the closure of the roots.
+// Rows reached from the roots or as children are followed to their parents and children,
+// rows reached as parents are only followed to their own parents, so a subset of one
+// customer doesn't pull in every other customer of the same country.
+type subset struct {
+	log    *xlog.Log
+	engine *sqlEngine
+	args   *common.Args
+	tables map[string]*core.Table
+	fks    []*foreignKey
+	// rows maps the table to its row keys, true if the row is followed to its children.
+	rows  map[string]map[string]bool
+	keys  map[string][][]string
+	queue []*subsetStep
+}
+
+// subsetFilters returns the filters dumping the closure of the roots.
+func subsetFilters(log *xlog.Log, args *common.Args, engine *sqlEngine, roots []SubsetRoot) map[string]*common.TableFilter {
+	tables, err := engine.DBMetas()
+	common.AssertNil(err)
+	s := &subset{
+		log:    log,
+		engine: engine,
+		args:   args,
+		tables: map[string]*core.Table{},
+		fks:    listForeignKeys(engine, args),
+		rows:   map[string]map[string]bool{},
+		keys:   map[string][][]string{},
+	}
+	for _, t := range tables {
+		s.tables[t.Name] = t
+	}
+
+	for _, root := range roots {
+		t, ok := s.tables[root.table]
+		if !ok {
+			common.AssertNil(fmt.Errorf("subset root table %s not found", root.table))
+		}
+		if len(t.PrimaryKeys) == 0 {
+			common.AssertNil(fmt.Errorf("subset root table %s has no primary key", root.table))
+		}
+		where := root.where
+		if root.percent > 0 {
+			// a fixed seed makes the sample repeatable.
+			where = fmt.Sprintf("RAND(42) < %v", root.percent/100)
+		}
+		keys := s.query(fmt.Sprintf("SELECT %s FROM `%s`.`%s` WHERE %s", quoteColumns(t.PrimaryKeys), args.Database, t.Name, where))
+		log.Info("subset.root[%s].where[%s].rows[%d]", t.Name, where, len(keys))
+		s.add(t.Name, keys, true)
+	}
+
+	for len(s.queue) > 0 {
+		step := s.queue[0]
+		s.queue = s.queue[1:]
+		s.follow(step)
+	}
+
+	filters := map[string]*common.TableFilter{}
+	for _, t := range tables {
+		keys := s.keys[t.Name]
+		f := &common.TableFilter{Rows: uint64(len(keys))}
+		for i := 0; i < len(keys); i += subsetBatch {
+			end := i + subsetBatch
+			if end > len(keys) {
+				end = len(keys)
+			}
+			f.Where = append(f.Where, inCondition(t.PrimaryKeys, keys[i:end]))
+		}
+		filters[t.Name] = f
+		log.Info("subset.table[%s].rows[%d]", t.Name, len(keys))
+	}
+	return filters
+}
+
+// add used to record the new rows of the table and queue them.
+func (s *subset) add(table string, keys [][]string, down bool) {
+	rows, ok := s.rows[table]
+	if !ok {
+		rows = map[string]bool{}
+		s.rows[table] = rows
+	}
+	var fresh [][]string
+	for _, k := range keys {
+		key := strings.Join(k, "\x00")
+		followed, ok := rows[key]
+		if ok && (followed || !down) {
+			continue
+		}
+		if !ok {
+			s.keys[table] = append(s.keys[table], k)
+		}
+		rows[key] = down
+		fresh = append(fresh, k)
+	}
+	if len(fresh) > 0 {
+		s.queue = append(s.queue, &subsetStep{table: table, keys: fresh, down: down})
+	}
+}
+
+// follow used to add the parents, and the children if step.down, of the rows.
+func (s *subset) follow(step *subsetStep) {
+	pk := s.tables[step.table].PrimaryKeys
+	for _, fk := range s.fks {
+		if fk.table == step.table {
+			parent, ok := s.primaryKey(fk.parent)
+			if !ok {
+				continue
+			}
+			values := s.selectIn(step.table, fk.cols, pk, step.keys)
+			s.add(fk.parent, s.selectIn(fk.parent, parent, fk.parentCols, values), false)
+		}
+		if step.down && fk.parent == step.table {
+			child, ok := s.primaryKey(fk.table)
+			if !ok {
+				continue
+			}
+			values := s.selectIn(step.table, fk.parentCols, pk, step.keys)
+			s.add(fk.table, s.selectIn(fk.table, child, fk.cols, values), true)
+		}
+	}
+}
+
+func (s *subset) primaryKey(table string) ([]string, bool) {
+	t, ok := s.tables[table]
+	if !ok || len(t.PrimaryKeys) == 0 {
+		s.log.Warning("subset.table[%s].has.no.primary.key, its rows are not followed", table)
+		return nil, false
+	}
+	return t.PrimaryKeys, true
+}
+
+// selectIn returns the distinct non NULL values of columns of the rows whose where columns are in values.
+func (s *subset) selectIn(table string, columns []string, where []string, values [][]string) [][]string {
+	var out [][]string
+	for i := 0; i < len(values); i += subsetBatch {
+		end := i + subsetBatch
+		if end > len(values) {
+			end = len(values)
+		}
+		out = append(out, s.query(fmt.Sprintf("SELECT DISTINCT %s FROM `%s`.`%s` WHERE %s", quoteColumns(columns), s.args.Database, table, inCondition(where, values[i:end])))...)
+	}
+	return out
+}
+
+// query returns the rows of the query, rows with a NULL are left out.
+func (s *subset) query(query string) [][]string {
+	rows, err := s.engine.DB().Query(query)
+	common.AssertNil(err)
+	defer rows.Close()
+	cols, err := rows.Columns()
+	common.AssertNil(err)
+
+	var out [][]string
+	for rows.Next() {
+		dest := make([]*string, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range dest {
+			ptrs[i] = &dest[i]
+		}
+		common.AssertNil(rows.Scan(ptrs...))
+		row := make([]string, len(cols))
+		valid := true
+		for i, v := range dest {
+			if v == nil {
+				valid = false
+				break
+			}
+			row[i] = *v
+		}
+		if valid {
+			out = append(out, row)
+		}
+	}
+	common.AssertNil(rows.Err())
+	return out
+}
+
+func quoteColumns(columns []string) string {
+	return "`" + strings.Join(columns, "`, `") + "`"
+}
+
+// inCondition returns "(`a`, `b`) IN (('1', 'x'), ...)".
+func inCondition(columns []string, values [][]string) string {
+	tuples := make([]string, len(values))
+	for i, v := range values {
+		quoted := make([]string, len(v))
+		for j, x := range v {
+			quoted[j] = "'" + common.EscapeString(x) + "'"
+		}
+		tuples[i] = "(" + strings.Join(quoted, ", ") + ")"
+	}
+	return fmt.Sprintf("(%s) IN (%s)", quoteColumns(columns), strings.Join(tuples, ", "))
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSubset(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []SubsetRoot
+		err  bool
+	}{
+		{"customers:id = 42", []SubsetRoot{{table: "customers", where: "id = 42"}}, false},
+		{" customers : id = 42 ; products: 5 % ;", []SubsetRoot{{table: "customers", where: "id = 42"}, {table: "products", percent: 5}}, false},
+		{"orders:created_at > '2026-01-01 00:00:00'", []SubsetRoot{{table: "orders", where: "created_at > '2026-01-01 00:00:00'"}}, false},
+		{"products:0.5%", []SubsetRoot{{table: "products", percent: 0.5}}, false},
+		{"products:100%", []SubsetRoot{{table: "products", percent: 100}}, false},
+		{"products:id % 2 = 0", []SubsetRoot{{table: "products", where: "id % 2 = 0"}}, false},
+		{"", nil, true},
+		{";", nil, true},
+		{"customers", nil, true},
+		{"customers:", nil, true},
+		{":id = 1", nil, true},
+		{"products:0%", nil, true},
+		{"products:101%", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSubset(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseSubset(%q) error = %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSubset(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestInCondition(t *testing.T) {
+	if got, want := inCondition([]string{"id"}, [][]string{{"1"}, {"2"}}), "(`id`) IN (('1'), ('2'))"; got != want {
+		t.Errorf("inCondition = %s, want %s", got, want)
+	}
+	if got, want := inCondition([]string{"a", "b"}, [][]string{{"1", "it's"}}), "(`a`, `b`) IN (('1', 'it\\'s'))"; got != want {
+		t.Errorf("inCondition = %s, want %s", got, want)
+	}
+}
+
+// TestSubsetAdd covers which rows are queued again: a row found as a parent is followed
+// to its children once it's found as a child or root too, never twice.
+func TestSubsetAdd(t *testing.T) {
+	s := &subset{rows: map[string]map[string]bool{}, keys: map[string][][]string{}}
+	steps := []struct {
+		keys [][]string
+		down bool
+		want [][]string
+	}{
+		{[][]string{{"1"}, {"2"}}, false, [][]string{{"1"}, {"2"}}},
+		{[][]string{{"2"}, {"3"}}, false, [][]string{{"3"}}},
+		{[][]string{{"2"}, {"4"}}, true, [][]string{{"2"}, {"4"}}},
+		{[][]string{{"1"}, {"2"}, {"4"}}, true, [][]string{{"1"}}},
+		{[][]string{{"1"}, {"4"}}, false, nil},
+	}
+	for i, step := range steps {
+		s.queue = nil
+		s.add("t", step.keys, step.down)
+		var got [][]string
+		for _, q := range s.queue {
+			if q.table != "t" || q.down != step.down {
+				t.Fatalf("step %d: bad step %+v", i, q)
+			}
+			got = append(got, q.keys...)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: queued %v, want %v", i, got, step.want)
+		}
+	}
+	if want := [][]string{{"1"}, {"2"}, {"3"}, {"4"}}; !reflect.DeepEqual(s.keys["t"], want) {
+		t.Errorf("keys %v, want %v", s.keys["t"], want)
+	}
+}
@@ -0,0 +1,78 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// tableChecksum used to compute the row count and an order independent checksum of the table,
+// the SUM of the CRC32 of every row, so the same data gives the same value on any server.
+// A sum keeps the duplicated rows, which BIT_XOR would cancel out, but two tables may still
+// collide: the CRC32 of a row is 32 bits, and changes of rows summing to 0 go unseen.
+func tableChecksum(q queryer, database string, table string) (*common.TableChecksum, error) {
+	qr, err := queryString(q, fmt.Sprintf("SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION", database, table))
+	if err != nil {
+		return nil, err
+	}
+	if len(qr) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found", database, table)
+	}
+
+	cols := make([]string, 0, len(qr))
+	nulls := make([]string, 0, len(qr))
+	for _, c := range qr {
+		col := fmt.Sprintf("`%s`", c["COLUMN_NAME"])
+		cols = append(cols, col)
+		nulls = append(nulls, fmt.Sprintf("ISNULL(%s)", col))
+	}
+	// CONCAT_WS skips NULL, so the null flags are part of the row.
+	row := fmt.Sprintf("CONCAT_WS('#', %s, CONCAT(%s))", strings.Join(cols, ", "), strings.Join(nulls, ", "))
+	res, err := queryString(q, fmt.Sprintf("SELECT COUNT(*) AS cnt, COALESCE(SUM(CRC32(%s)), 0) AS crc FROM `%s`.`%s`", row, database, table))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := strconv.ParseUint(res[0]["cnt"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &common.TableChecksum{Rows: rows, Checksum: res[0]["crc"]}, nil
+}
+
+// verifyRestore used to recompute the checksums of the restored tables and compare them with the dump.
+func verifyRestore(log *xlog.Log, args *common.Args, engine *sqlEngine) bool {
+	if args.Manifest == nil || len(args.Manifest.Checksums) == 0 {
+		log.Error("verify.no.checksums.in.manifest, dump with '-verify' first")
+		return false
+	}
+
+	tables := make([]string, 0, len(args.Manifest.Checksums))
+	for t := range args.Manifest.Checksums {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	failed := 0
+	for _, t := range tables {
+		expect := args.Manifest.Checksums[t]
+		_, t = args.Renames.Table(args.SourceDatabase, t)
+		got, err := tableChecksum(engine.DB(), args.Database, t)
+		switch {
+		case err != nil:
+			failed++
+			log.Error("verify.table[%s.%s].FAIL.error:%v", args.Database, t, err)
+		case got.Rows != expect.Rows || got.Checksum != expect.Checksum:
+			failed++
+			log.Error("verify.table[%s.%s].FAIL.rows[%v/%v].checksum[%s/%s]", args.Database, t, got.Rows, expect.Rows, got.Checksum, expect.Checksum)
+		default:
+			log.Info("verify.table[%s.%s].PASS.rows[%v].checksum[%s]", args.Database, t, got.Rows, got.Checksum)
+		}
+	}
+	log.Info("verify.all.done.tables[%d].passed[%d].failed[%d]", len(tables), len(tables)-failed, failed)
+	return failed == 0
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"context"
+	"database/sql/driver"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"xorm.io/core"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestTableChecksum(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT COLUMN_NAME", []string{"COLUMN_NAME"}, []driver.Value{[]byte("id")}, []driver.Value{[]byte("name")})
+	f.answer("SELECT COUNT(*)", []string{"cnt", "crc"}, []driver.Value{[]byte("3"), []byte("9876543210")})
+
+	c, err := tableChecksum(db, "db", "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Rows != 3 || c.Checksum != "9876543210" {
+		t.Errorf("tableChecksum = %+v, want 3 rows and checksum 9876543210", c)
+	}
+	stmts := f.statements()
+	want := "SELECT COUNT(*) AS cnt, COALESCE(SUM(CRC32(CONCAT_WS('#', `id`, `name`, CONCAT(ISNULL(`id`), ISNULL(`name`))))), 0) AS crc FROM `db`.`t`"
+	if len(stmts) != 2 || stmts[1][3:] != want {
+		t.Errorf("statements = %q, want the checksum %q", stmts, want)
+	}
+}
+
+func TestTableChecksumNotFound(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT COLUMN_NAME", []string{"COLUMN_NAME"})
+	if _, err := tableChecksum(db, "db", "t"); err == nil {
+		t.Error("tableChecksum of a missing table: want error")
+	}
+}
+
+func TestDumpTableVerifySnapshot(t *testing.T) {
+	f, db := newFakeDB(t)
+	defer db.Close()
+	f.answer("SELECT COLUMN_NAME", []string{"COLUMN_NAME"}, []driver.Value{[]byte("id")})
+	f.answer("SELECT COUNT(*)", []string{"cnt", "crc"}, []driver.Value{[]byte("2"), []byte("77")})
+	f.answer("SELECT /*backup*/", []string{"id"}, []driver.Value{int64(1)}, []driver.Value{int64(2)})
+
+	table := core.NewEmptyTable()
+	table.Name = "t"
+	table.StoreEngine = "InnoDB"
+	table.AddColumn(&core.Column{Name: "id", SQLType: core.SQLType{Name: core.Int}, IsPrimaryKey: true, Nullable: false})
+	table.PrimaryKeys = []string{"id"}
+
+	args := &common.Args{Context: context.Background(), Database: "db", Format: formatSQL, StmtSize: 1000, ChunksizeInMB: 1, Verify: true}
+	engine := &sqlEngine{db: db, dialect: core.QueryDialect(core.MYSQL)}
+	log := xlog.NewXLog(ioutil.Discard)
+	var chunks []string
+	c := dumpTable(log, engine, args, newProgress(log, args, "dumping"), table, func(fileNo int, rows uint64, data string) {
+		chunks = append(chunks, data)
+	})
+	if c == nil || c.Rows != 2 || c.Checksum != "77" {
+		t.Fatalf("dumpTable checksum = %+v, want 2 rows and checksum 77", c)
+	}
+	if len(chunks) != 1 || !strings.Contains(chunks[0], "(1),\n(2);") {
+		t.Errorf("chunks = %q", chunks)
+	}
+
+	// the checksum and the rows are read on one connection, in one transaction.
+	stmts := f.statements()
+	if len(stmts) != 5 {
+		t.Fatalf("statements = %q, want 5", stmts)
+	}
+	conn := stmts[0][:3]
+	for i, prefix := range []string{"BEGIN isolation=Repeatable Read read_only=true", "SELECT COLUMN_NAME", "SELECT COUNT(*)", "SELECT /*backup*/", "ROLLBACK"} {
+		if stmts[i][:3] != conn || !strings.HasPrefix(stmts[i][3:], prefix) {
+			t.Errorf("statement %d = %q, want %q on the connection of %q", i, stmts[i], prefix, stmts[0])
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+// ParseWatermarks used to parse the watermark columns like "orders:id,events:updated_at",
+// table '*' sets the column of every table having it.
+func ParseWatermarks(spec string) (map[string]string, error) {
+	columns := map[string]string{}
+	for _, s := range strings.Split(spec, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("watermark %q: expect 'table:column'", s)
+		}
+		columns[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return columns, nil
+}
+
+// watermarkFilters returns the watermarks of the tables and, for an incremental dump, the filters
+// of the rows beyond the watermarks of prev. The upper bound is the max value read now, the rows
+// written during the dump are left to the next one.
+func watermarkFilters(log *xlog.Log, args *common.Args, engine *sqlEngine, columns map[string]string, prev *common.Manifest) (map[string]*common.Watermark, map[string]*common.TableFilter) {
+	tables, err := engine.DBMetas()
+	common.AssertNil(err)
+
+	watermarks := map[string]*common.Watermark{}
+	filters := map[string]*common.TableFilter{}
+	for _, t := range tables {
+		column, ok := columns[t.Name]
+		if !ok && prev != nil && prev.Watermarks[t.Name] != nil {
+			column, ok = prev.Watermarks[t.Name].Column, true
+		}
+		if !ok && columns["*"] != "" && t.GetColumn(columns["*"]) != nil {
+			column, ok = columns["*"], true
+		}
+		if !ok {
+			if prev != nil {
+				log.Warning("dumping.table[%s.%s].has.no.watermark, dumped whole", args.Database, t.Name)
+			}
+			continue
+		}
+		if t.GetColumn(column) == nil {
+			common.AssertNil(fmt.Errorf("watermark column %s.%s not found", t.Name, column))
+		}
+
+		qr, err := engine.QueryString(fmt.Sprintf("SELECT IFNULL(MAX(`%s`), '') AS watermark FROM `%s`.`%s`", column, args.Database, t.Name))
+		common.AssertNil(err)
+		w := &common.Watermark{Column: column, Value: qr[0]["watermark"]}
+		watermarks[t.Name] = w
+		log.Info("dumping.table[%s.%s].watermark[%s=%s]", args.Database, t.Name, column, w.Value)
+		if prev == nil {
+			continue
+		}
+
+		var conds []string
+		// the boundary value is dumped again in case more rows got it later, the upserts make it harmless.
+		if from := prev.Watermarks[t.Name]; from != nil && from.Column == column && from.Value != "" {
+			conds = append(conds, fmt.Sprintf("`%s` >= '%s'", column, common.EscapeString(from.Value)))
+		}
+		if w.Value == "" {
+			filters[t.Name] = &common.TableFilter{}
+			continue
+		}
+		conds = append(conds, fmt.Sprintf("`%s` <= '%s'", column, common.EscapeString(w.Value)))
+		filters[t.Name] = &common.TableFilter{Where: []string{strings.Join(conds, " AND ")}}
+	}
+	return watermarks, filters
+}
+
+// mergeFilters returns the filters dumping the rows matching both a and b, a table filtered by
+// only one of them keeps its filter.
+func mergeFilters(a, b map[string]*common.TableFilter) map[string]*common.TableFilter {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	filters := map[string]*common.TableFilter{}
+	for name, f := range a {
+		filters[name] = f
+	}
+	for name, g := range b {
+		f, ok := filters[name]
+		if !ok {
+			filters[name] = g
+			continue
+		}
+		merged := &common.TableFilter{Rows: f.Rows}
+		for _, x := range f.Where {
+			for _, y := range g.Where {
+				merged.Where = append(merged.Where, andCondition(x, y))
+			}
+		}
+		filters[name] = merged
+	}
+	return filters
+}
+
+// andCondition returns the condition matching both x and y, an empty condition matches every row.
+func andCondition(x, y string) string {
+	switch {
+	case x == "":
+		return y
+	case y == "":
+		return x
+	}
+	return fmt.Sprintf("(%s) AND (%s)", x, y)
+}
+
+// incremental returns true if the dump being loaded is an incremental one.
+func incremental(args *common.Args) bool {
+	return args.Manifest != nil && args.Manifest.IncrementalFrom != ""
+}
@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"reflect"
+	"testing"
+
+	"mysqldump/common"
+)
+
+func TestParseWatermarks(t *testing.T) {
+	tests := []struct {
+		spec string
+		want map[string]string
+		err  bool
+	}{
+		{"orders:id", map[string]string{"orders": "id"}, false},
+		{" orders : id , *:updated_at,", map[string]string{"orders": "id", "*": "updated_at"}, false},
+		{"", map[string]string{}, false},
+		{"orders", nil, true},
+		{"orders:", nil, true},
+		{":id", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseWatermarks(tt.spec)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseWatermarks(%q) error = %v, want error %v", tt.spec, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseWatermarks(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestMergeFilters(t *testing.T) {
+	subset := map[string]*common.TableFilter{
+		"orders": {Where: []string{"`id` IN (1,2)", "`id` IN (3)"}, Rows: 3},
+		"users":  {Where: nil},
+		"items":  {Where: []string{"`id` IN (7)"}, Rows: 1},
+	}
+	watermark := map[string]*common.TableFilter{
+		"orders": {Where: []string{"`id` <= '9'"}},
+		"users":  {Where: []string{"`id` <= '5'"}},
+		"events": {Where: []string{"`ts` <= '2020'"}},
+	}
+	want := map[string]*common.TableFilter{
+		"orders": {Where: []string{"(`id` IN (1,2)) AND (`id` <= '9')", "(`id` IN (3)) AND (`id` <= '9')"}, Rows: 3},
+		"users":  {Rows: 0},
+		"items":  {Where: []string{"`id` IN (7)"}, Rows: 1},
+		"events": {Where: []string{"`ts` <= '2020'"}},
+	}
+	got := mergeFilters(subset, watermark)
+	if !reflect.DeepEqual(got, want) {
+		for name, f := range got {
+			t.Logf("%s: %+v", name, *f)
+		}
+		t.Fatalf("mergeFilters mismatch")
+	}
+
+	if got := mergeFilters(subset, map[string]*common.TableFilter{}); !reflect.DeepEqual(got, subset) {
+		t.Errorf("mergeFilters with no watermark filters = %v, want the subset filters", got)
+	}
+	if got := mergeFilters(nil, watermark); !reflect.DeepEqual(got, watermark) {
+		t.Errorf("mergeFilters with no subset filters = %v, want the watermark filters", got)
+	}
+}
+
+func TestAndCondition(t *testing.T) {
+	tests := []struct{ x, y, want string }{
+		{"", "", ""},
+		{"a = 1", "", "a = 1"},
+		{"", "b = 2", "b = 2"},
+		{"a = 1 OR a = 2", "b = 2", "(a = 1 OR a = 2) AND (b = 2)"},
+	}
+	for _, tt := range tests {
+		if got := andCondition(tt.x, tt.y); got != tt.want {
+			t.Errorf("andCondition(%q, %q) = %q, want %q", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
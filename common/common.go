@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
@@ -13,6 +14,8 @@ type Args struct {
 	Database      string
 	Outdir        string
 	ExcludeTables string
+	// Format of the data chunks, sql, csv or tsv.
+	Format        string
 	Threads       int
 	ChunksizeInMB int
 	StmtSize      int
@@ -21,6 +24,61 @@ type Args struct {
 
 	// Interval in millisecond.
 	IntervalMs int
+
+	// ProgressFormat is text or json.
+	ProgressFormat string
+	ProgressFile   string
+	// ProgressOut is where the json lines go.
+	ProgressOut io.Writer
+	// OnProgress is called with every progress report.
+	OnProgress func(s *ProgressStatus)
+	// OnTable is called when a table of the stage changes its status.
+	OnTable func(stage string, t TableProgress)
+	// Context cancels the run, the workers check it between the statements.
+	Context context.Context
+
+	// Manifest lists the files written by the dumper or read by the loader.
+	Manifest *Manifest
+	// IgnoreChecksum used to only warn about files not matching the manifest.
+	IgnoreChecksum bool
+	// Verify used to checksum tables on dump and compare them after restore.
+	Verify bool
+
+	// Renames holds the rename rules of the restore.
+	Renames *Renames
+	// SourceDatabase is the name of the database in the dump.
+	SourceDatabase string
+
+	// Masking holds the column masking rules of the dump.
+	Masking *Masking
+	// Filters limits the rows to dump, a table not in Filters is dumped whole.
+	Filters map[string]*TableFilter
+	// Watermarks are the max values of the watermark columns at the start of the dump.
+	Watermarks map[string]*Watermark
+	// IncrementalFrom is the previous dump of an incremental dump.
+	IncrementalFrom string
+	// Cipher encrypts the dump files, nil if they are plaintext.
+	Cipher *Cipher
+	// Storage keeps the files of the dump directory Outdir.
+	Storage Storage
+	// Hooks run before/after the run and each table.
+	Hooks *Hooks
+	// Retries is the max retries of a statement or chunk failing with a transient error, 0 to never retry.
+	Retries int
+	// RetryBudget is the retries left for the whole run, shared by the workers.
+	RetryBudget int64
+	// MaxAllowedPacket of the server the statements go to, 0 if unknown.
+	MaxAllowedPacket int
+	// NoData, NoSchema, NoRoutines and NoViews skip the rows, the tables(and the triggers and events
+	// of a loaded sql file), the functions and procedures, and the views of the dump, load or copy.
+	NoData, NoSchema, NoRoutines, NoViews bool
+}
+
+// TableFilter holds the WHERE conditions of a table, the rows of every condition are dumped.
+type TableFilter struct {
+	Where []string
+	// Rows is the expected row count, 0 if unknown.
+	Rows uint64
 }
 
 // BytesToString casts slice to string without copy
@@ -125,3 +183,8 @@ func EscapeString(v string) string {
 	}
 	return string(buf[:pos])
 }
+
+// MB used to convert bytes to megabytes without truncation.
+func MB(bytes uint64) float64 {
+	return float64(bytes) / 1024 / 1024
+}
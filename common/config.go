@@ -0,0 +1,273 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Config holds the key/values of a config file by section, the top level keys are in section "".
+type Config map[string]map[string]string
+
+// ReadConfig used to read the config file.
+func ReadConfig(file string) (Config, error) {
+	data, err := ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig used to parse a TOML config, the tables are the sections and the values are kept as strings:
+//
+//	host = "127.0.0.1"
+//	port = 3306
+//
+//	[dump]
+//	threads = 8
+//	exclude = ["logs", "events"] # joined by ','
+//
+// Dotted keys and table names are joined by '.', multi-line strings and arrays, inline tables and
+// arrays of tables are not supported.
+func ParseConfig(data []byte) (Config, error) {
+	cfg := Config{"": {}}
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for no := 1; scanner.Scan(); no++ {
+		p := &configParser{s: scanner.Text()}
+		if err := p.line(cfg, &section); err != nil {
+			return nil, fmt.Errorf("config line %d: %v", no, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configParser reads one line of a config.
+type configParser struct {
+	s   string
+	pos int
+}
+
+func (p *configParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *configParser) peek(c byte) bool {
+	return p.pos < len(p.s) && p.s[p.pos] == c
+}
+
+// end used to check only a comment is left on the line.
+func (p *configParser) end() error {
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] != '#' {
+		return fmt.Errorf("unexpected %q", p.s[p.pos:])
+	}
+	return nil
+}
+
+func (p *configParser) line(cfg Config, section *string) error {
+	if err := p.end(); err == nil {
+		return nil
+	}
+	if p.peek('[') {
+		p.pos++
+		if p.peek('[') {
+			return errors.New("arrays of tables are not supported")
+		}
+		name, err := p.key()
+		if err != nil {
+			return err
+		}
+		p.skipSpace()
+		if !p.peek(']') {
+			return errors.New("expect ']' after the table name")
+		}
+		p.pos++
+		if err := p.end(); err != nil {
+			return err
+		}
+		if _, ok := cfg[name]; ok {
+			return fmt.Errorf("table [%s] defined twice", name)
+		}
+		cfg[name] = map[string]string{}
+		*section = name
+		return nil
+	}
+
+	key, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.peek('=') {
+		return fmt.Errorf("expect '=' after the key %s", key)
+	}
+	p.pos++
+	p.skipSpace()
+	value, err := p.value(true)
+	if err == nil {
+		err = p.end()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	if _, ok := cfg[*section][key]; ok {
+		return fmt.Errorf("key %s defined twice", key)
+	}
+	cfg[*section][key] = value
+	return nil
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// key reads a bare, quoted or dotted key.
+func (p *configParser) key() (string, error) {
+	var parts []string
+	for {
+		p.skipSpace()
+		switch {
+		case p.peek('"') || p.peek('\''):
+			part, err := p.str()
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, part)
+		case p.pos < len(p.s) && isBareKey(p.s[p.pos]):
+			start := p.pos
+			for p.pos < len(p.s) && isBareKey(p.s[p.pos]) {
+				p.pos++
+			}
+			parts = append(parts, p.s[start:p.pos])
+		default:
+			return "", fmt.Errorf("expect a key, got %q", p.s[p.pos:])
+		}
+		p.skipSpace()
+		if !p.peek('.') {
+			return strings.Join(parts, "."), nil
+		}
+		p.pos++
+	}
+}
+
+// str reads a basic string with its escapes or a literal string, up to the matching quote.
+func (p *configParser) str() (string, error) {
+	quote := p.s[p.pos]
+	if strings.HasPrefix(p.s[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", errors.New("multi-line strings are not supported")
+	}
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		p.pos++
+		switch {
+		case c == quote:
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated string %s", p.s[start:])
+}
+
+var configEscapes = map[byte]byte{'b': '\b', 't': '\t', 'n': '\n', 'f': '\f', 'r': '\r', '"': '"', '\\': '\\'}
+
+func (p *configParser) escape(b *strings.Builder) error {
+	if p.pos == len(p.s) {
+		return errors.New("unterminated string")
+	}
+	c := p.s[p.pos]
+	p.pos++
+	if e, ok := configEscapes[c]; ok {
+		b.WriteByte(e)
+		return nil
+	}
+	n := 4
+	if c == 'U' {
+		n = 8
+	} else if c != 'u' {
+		return fmt.Errorf("bad escape \\%c", c)
+	}
+	if p.pos+n > len(p.s) {
+		return fmt.Errorf("bad escape \\%c%s", c, p.s[p.pos:])
+	}
+	r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+	if err != nil || !utf8.ValidRune(rune(r)) {
+		return fmt.Errorf("bad escape \\%c%s", c, p.s[p.pos:p.pos+n])
+	}
+	p.pos += n
+	b.WriteRune(rune(r))
+	return nil
+}
+
+// bareValue matches the booleans, integers, floats and date-times.
+var bareValue = regexp.MustCompile(`^(true|false|[+-]?(inf|nan)|[+-]?[0-9][0-9_]*(\.[0-9_]+)?([eE][+-]?[0-9_]+)?|0x[0-9a-fA-F_]+|0o[0-7_]+|0b[01_]+|[0-9]{4}-[0-9]{2}-[0-9]{2}([Tt][0-9:.]+([Zz]|[+-][0-9]{2}:[0-9]{2})?)?|[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?)$`)
+
+// value reads a string, a bare value or an array of them joined by ','.
+func (p *configParser) value(array bool) (string, error) {
+	switch {
+	case p.peek('"') || p.peek('\''):
+		return p.str()
+	case p.peek('[') && array:
+		p.pos++
+		var values []string
+		for {
+			p.skipSpace()
+			if p.peek(']') {
+				p.pos++
+				return strings.Join(values, ","), nil
+			}
+			v, err := p.value(false)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, v)
+			p.skipSpace()
+			if p.peek(',') {
+				p.pos++
+			} else if !p.peek(']') {
+				return "", errors.New("expect ',' or ']' in the array, arrays must be on one line")
+			}
+		}
+	case p.peek('[') || p.peek('{'):
+		return "", errors.New("inline tables and nested arrays are not supported")
+	}
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t#,]", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	v := p.s[start:p.pos]
+	if v == "" {
+		return "", errors.New("expect a value")
+	}
+	if !bareValue.MatchString(v) {
+		return "", fmt.Errorf("bad value %q, strings must be quoted", v)
+	}
+	return v, nil
+}
+
+// Lookup returns the value of key in section, or in the top level.
+func (c Config) Lookup(section, key string) (string, bool) {
+	if v, ok := c[section][key]; ok {
+		return v, true
+	}
+	v, ok := c[""][key]
+	return v, ok
+}
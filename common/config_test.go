@@ -0,0 +1,92 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want Config
+	}{
+		{
+			"sections",
+			"host = \"127.0.0.1\"\nport = 3306\n\n[dump]\nthreads = 8\n",
+			Config{"": {"host": "127.0.0.1", "port": "3306"}, "dump": {"threads": "8"}},
+		},
+		{"comment with a quote", `key = "v" # "x"`, Config{"": {"key": "v"}}},
+		{"literal comment with a quote", `key = 'v' # 'x'`, Config{"": {"key": "v"}}},
+		{"hash in a string", `key = "a # b" # c`, Config{"": {"key": "a # b"}}},
+		{"escapes", `key = "a\"b\\c\tdé\u00e9"`, Config{"": {"key": "a\"b\\c\tdéé"}}},
+		{"literal has no escapes", `key = 'C:\dir\"'`, Config{"": {"key": `C:\dir\"`}}},
+		{"literal with double quotes", `notify = '-sh:curl -d "$X"'`, Config{"": {"notify": `-sh:curl -d "$X"`}}},
+		{"bare values", "a = true\nb = -1_000\nc = 1.5e3 # x\nd = 2026-10-01T12:00:00Z", Config{"": {"a": "true", "b": "-1_000", "c": "1.5e3", "d": "2026-10-01T12:00:00Z"}}},
+		{"array", `exclude = ["logs", 'events' , 3] # "x"`, Config{"": {"exclude": "logs,events,3"}}},
+		{"empty array", `exclude = []`, Config{"": {"exclude": ""}}},
+		{"dotted key", `orders . "user email" = "email"`, Config{"": {"orders.user email": "email"}}},
+		{"quoted table", "[ \"db.orders\" ] # x\nid = \"hash\"", Config{"": {}, "db.orders": {"id": "hash"}}},
+		{"bare key with digits", "[before]\n1-cron = \"sh:true\"", Config{"": {}, "before": {"1-cron": "sh:true"}}},
+		{"empty string", `key = ""`, Config{"": {"key": ""}}},
+		{"blank and comments", "\n  # x = 1\n\t\n", Config{"": {}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseConfig([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  string
+	}{
+		{`key = "v`, "config line 1: key: unterminated string"},
+		{`key = "v" x`, `unexpected "x"`},
+		{`key = 'v' "x"`, `unexpected "\"x\""`},
+		{`key = value`, "strings must be quoted"},
+		{`key =`, "expect a value"},
+		{`key "v"`, "expect '=' after the key key"},
+		{`= 1`, "expect a key"},
+		{`key = "\q"`, `bad escape \q`},
+		{`key = "\u12"`, `bad escape \u12`},
+		{`key = """v"""`, "multi-line strings are not supported"},
+		{`key = ["a" "b"]`, "expect ',' or ']'"},
+		{`key = [["a"]]`, "nested arrays are not supported"},
+		{`key = {a = 1}`, "inline tables"},
+		{"[dump", "expect ']'"},
+		{"[[dump]]", "arrays of tables are not supported"},
+		{"[dump]\n[dump]", "config line 2: table [dump] defined twice"},
+		{"a = 1\na = 2", "config line 2: key a defined twice"},
+	}
+	for _, tt := range tests {
+		_, err := ParseConfig([]byte(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ParseConfig(%q) = %v, want an error containing %q", tt.data, err, tt.err)
+		}
+	}
+}
+
+func TestConfigLookup(t *testing.T) {
+	cfg := Config{"": {"host": "a", "port": "1"}, "dump": {"host": "b"}}
+	for _, tt := range []struct{ section, key, want string }{
+		{"dump", "host", "b"},
+		{"dump", "port", "1"},
+		{"load", "host", "a"},
+	} {
+		if got, ok := cfg.Lookup(tt.section, tt.key); !ok || got != tt.want {
+			t.Errorf("Lookup(%s, %s) = %q, %v, want %q", tt.section, tt.key, got, ok, tt.want)
+		}
+	}
+	if _, ok := cfg.Lookup("dump", "user"); ok {
+		t.Errorf("Lookup(dump, user) found a value")
+	}
+}
@@ -0,0 +1,226 @@
+package common
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+)
+
+// The encrypted file is the header followed by the segments of the plaintext, every segment
+// is sealed with AES-256-GCM by the file key, the nonce is the segment number and a flag of
+// the last segment, so reordered, dropped or truncated segments fail to open:
+//
+//	magic(6) kdf(1) kdf salt(16) kdf iterations(4) file salt(16) | segment(64KB + 16)...
+const (
+	cryptMagic       = "MDENC\x01"
+	cryptHeaderSize  = len(cryptMagic) + 1 + 16 + 4 + 16
+	cryptSegmentSize = 64 * 1024
+	cryptIterations  = 200000
+	// cryptMaxIterations bounds the iterations read from a file, the header isn't authenticated
+	// until the key is derived, so a crafted file could make it spin.
+	cryptMaxIterations = 16 * cryptIterations
+
+	kdfKeyFile    = 0
+	kdfPassphrase = 1
+)
+
+// Cipher used to encrypt and decrypt the dump files, the key is from a key file or a passphrase.
+type Cipher struct {
+	kdf        byte
+	salt       []byte
+	iterations uint32
+	key        []byte
+	passphrase []byte
+
+	mu sync.Mutex
+	// keys caches the keys of the passphrase by the kdf salt.
+	keys map[string][]byte
+}
+
+// NewKeyFileCipher creates a cipher with the 32 bytes key of the file, raw or hex encoded.
+func NewKeyFileCipher(file string) (*Cipher, error) {
+	data, err := ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key := data
+	if text := strings.TrimSpace(string(data)); len(text) == 64 {
+		if key, err = hex.DecodeString(text); err != nil {
+			return nil, fmt.Errorf("key file %s: %v", file, err)
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key file %s must have 32 bytes or 64 hex chars, create one with 'openssl rand -hex 32'", file)
+	}
+	return &Cipher{kdf: kdfKeyFile, salt: make([]byte, 16), key: key}, nil
+}
+
+// NewPassphraseCipher creates a cipher with the key derived from the passphrase by PBKDF2-SHA256.
+func NewPassphraseCipher(passphrase string) (*Cipher, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	c := &Cipher{kdf: kdfPassphrase, salt: salt, iterations: cryptIterations, passphrase: []byte(passphrase), keys: map[string][]byte{}}
+	c.key = c.passphraseKey(salt, cryptIterations)
+	return c, nil
+}
+
+func (c *Cipher) passphraseKey(salt []byte, iterations uint32) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := string(salt) + fmt.Sprint(iterations)
+	if key, ok := c.keys[id]; ok {
+		return key
+	}
+	key := pbkdf2(c.passphrase, salt, int(iterations), 32, sha256.New)
+	c.keys[id] = key
+	return key
+}
+
+// pbkdf2 used to derive a key as RFC 8018.
+func pbkdf2(password, salt []byte, iterations, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	size := prf.Size()
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		_ = binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t[:size]...)
+	}
+	return key[:keyLen]
+}
+
+// IsEncrypted returns true if data is an encrypted file.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(cryptMagic))
+}
+
+func fileAEAD(key, fileSalt []byte) (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fileSalt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func segmentNonce(n uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// Seal used to encrypt the file data.
+func (c *Cipher) Seal(plain []byte) ([]byte, error) {
+	header := make([]byte, 0, cryptHeaderSize)
+	header = append(header, cryptMagic...)
+	header = append(header, c.kdf)
+	header = append(header, c.salt...)
+	header = append(header, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(header[len(header)-4:], c.iterations)
+	fileSalt := make([]byte, 16)
+	if _, err := rand.Read(fileSalt); err != nil {
+		return nil, err
+	}
+	header = append(header, fileSalt...)
+
+	aead, err := fileAEAD(c.key, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+	segments := len(plain)/cryptSegmentSize + 1
+	out := make([]byte, 0, cryptHeaderSize+len(plain)+segments*aead.Overhead())
+	out = append(out, header...)
+	for n := 0; n < segments; n++ {
+		end := (n + 1) * cryptSegmentSize
+		if end > len(plain) {
+			end = len(plain)
+		}
+		out = aead.Seal(out, segmentNonce(uint64(n), n == segments-1), plain[n*cryptSegmentSize:end], header)
+	}
+	return out, nil
+}
+
+// Open used to decrypt the file data, a nil Cipher returns the data as is if it's not encrypted.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		if c != nil {
+			return nil, errors.New("file is not encrypted")
+		}
+		return data, nil
+	}
+	if c == nil {
+		return nil, errors.New("file is encrypted, need '-encrypt-key' or '-encrypt-passphrase'")
+	}
+	if len(data) < cryptHeaderSize {
+		return nil, errors.New("encrypted file is truncated")
+	}
+	header := data[:cryptHeaderSize]
+	kdf := header[len(cryptMagic)]
+	salt := header[len(cryptMagic)+1 : len(cryptMagic)+17]
+	iterations := binary.BigEndian.Uint32(header[len(cryptMagic)+17:])
+	fileSalt := header[cryptHeaderSize-16:]
+
+	key := c.key
+	switch {
+	case kdf != c.kdf && kdf == kdfPassphrase:
+		return nil, errors.New("file is encrypted with a passphrase, need '-encrypt-passphrase'")
+	case kdf != c.kdf:
+		return nil, errors.New("file is encrypted with a key file, need '-encrypt-key'")
+	case kdf == kdfPassphrase && (iterations == 0 || iterations > cryptMaxIterations):
+		return nil, fmt.Errorf("file has %d kdf iterations, expect 1 to %d", iterations, cryptMaxIterations)
+	case kdf == kdfPassphrase && (!bytes.Equal(salt, c.salt) || iterations != c.iterations):
+		key = c.passphraseKey(salt, iterations)
+	}
+	aead, err := fileAEAD(key, fileSalt)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := cryptSegmentSize + aead.Overhead()
+	rest := data[cryptHeaderSize:]
+	plain := make([]byte, 0, len(rest))
+	for n := uint64(0); ; n++ {
+		last := len(rest) <= sealed
+		seg := rest
+		if !last {
+			seg = rest[:sealed]
+		}
+		if plain, err = aead.Open(plain, segmentNonce(n, last), seg, header); err != nil {
+			return nil, fmt.Errorf("segment %d can't be decrypted, the file is tampered, truncated or encrypted with another key", n)
+		}
+		if last {
+			return plain, nil
+		}
+		rest = rest[sealed:]
+	}
+}
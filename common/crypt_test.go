@@ -0,0 +1,165 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPBKDF2(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		iterations     int
+		sha256         bool
+		want           string
+	}{
+		// RFC 6070
+		{"password", "salt", 1, false, "0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, false, "ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, false, "4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword", "saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, false, "3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"pass\x00word", "sa\x00lt", 4096, false, "56fa6aa75548099dcc37d7f03425e0c3"},
+		// RFC 7914
+		{"passwd", "salt", 1, true, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, true, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		h := sha1.New
+		if tt.sha256 {
+			h = sha256.New
+		}
+		got := hex.EncodeToString(pbkdf2([]byte(tt.password), []byte(tt.salt), tt.iterations, len(tt.want)/2, h))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func testCiphers(t *testing.T) map[string]*Cipher {
+	dir, err := ioutil.TempDir("", "crypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(file, []byte(strings.Repeat("ab", 32)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, err := NewKeyFileCipher(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase, err := NewPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*Cipher{"key file": keyFile, "passphrase": passphrase}
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	for name, c := range testCiphers(t) {
+		for _, size := range []int{0, 1, cryptSegmentSize - 1, cryptSegmentSize, cryptSegmentSize + 1, 3*cryptSegmentSize + 7} {
+			plain := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+			sealed, err := c.Seal(plain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(sealed) || bytes.Contains(sealed, []byte("0123456789abcdef")) {
+				t.Fatalf("%s: sealed %d bytes are not encrypted", name, size)
+			}
+			got, err := c.Open(sealed)
+			if err != nil {
+				t.Fatalf("%s: open %d bytes: %v", name, size, err)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatalf("%s: open %d bytes: the plaintext differs", name, size)
+			}
+		}
+	}
+
+	// another cipher of the passphrase has another salt, the file has the one it's sealed with.
+	a, _ := NewPassphraseCipher("secret")
+	b, _ := NewPassphraseCipher("secret")
+	sealed, _ := a.Seal([]byte("data"))
+	if got, err := b.Open(sealed); err != nil || string(got) != "data" {
+		t.Errorf("open with another cipher of the passphrase = %q, %v", got, err)
+	}
+	wrong, _ := NewPassphraseCipher("wrong")
+	if _, err := wrong.Open(sealed); err == nil {
+		t.Error("open with a wrong passphrase: want error")
+	}
+}
+
+func TestCipherTampered(t *testing.T) {
+	c := testCiphers(t)["key file"]
+	plain := bytes.Repeat([]byte{'x'}, 3*cryptSegmentSize+100)
+	sealed, err := c.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seg := cryptSegmentSize + 16
+	body := func(n int) []byte { return sealed[cryptHeaderSize+n*seg : cryptHeaderSize+(n+1)*seg] }
+	last := sealed[cryptHeaderSize+3*seg:]
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(append([][]byte{sealed[:cryptHeaderSize]}, parts...), nil)
+	}
+
+	tests := map[string][]byte{
+		"truncated header":       sealed[:cryptHeaderSize-1],
+		"last segment dropped":   join(body(0), body(1), body(2)),
+		"last segment truncated": sealed[:len(sealed)-1],
+		"last segment cut":       sealed[:len(sealed)-len(last)+5],
+		"segments reordered":     join(body(1), body(0), body(2), last),
+		"last segment moved":     join(body(0), body(1), last, body(2)),
+		"segment duplicated":     join(body(0), body(0), body(2), last),
+		"byte flipped":           func() []byte { d := append([]byte(nil), sealed...); d[cryptHeaderSize+10] ^= 1; return d }(),
+		"header flipped":         func() []byte { d := append([]byte(nil), sealed...); d[cryptHeaderSize-1] ^= 1; return d }(),
+	}
+	for name, data := range tests {
+		if _, err := c.Open(data); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestCipherIterationsBound(t *testing.T) {
+	c, _ := NewPassphraseCipher("secret")
+	sealed, _ := c.Seal([]byte("data"))
+	for _, iterations := range []uint32{0, cryptMaxIterations + 1, 1<<32 - 1} {
+		data := append([]byte(nil), sealed...)
+		binary.BigEndian.PutUint32(data[len(cryptMagic)+17:], iterations)
+		start := time.Now()
+		if _, err := c.Open(data); err == nil || !strings.Contains(err.Error(), "iterations") {
+			t.Errorf("open with %d iterations = %v, want the iterations error", iterations, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Errorf("open with %d iterations took %v", iterations, time.Since(start))
+		}
+	}
+}
+
+func TestCipherMismatch(t *testing.T) {
+	ciphers := testCiphers(t)
+	sealed, _ := ciphers["key file"].Seal([]byte("data"))
+	if _, err := ciphers["passphrase"].Open(sealed); err == nil || !strings.Contains(err.Error(), "-encrypt-key") {
+		t.Errorf("open a key file dump with a passphrase = %v", err)
+	}
+	var none *Cipher
+	if _, err := none.Open(sealed); err == nil {
+		t.Error("open an encrypted file without a cipher: want error")
+	}
+	if got, err := none.Open([]byte("plain")); err != nil || string(got) != "plain" {
+		t.Errorf("open a plain file without a cipher = %q, %v", got, err)
+	}
+	if _, err := ciphers["key file"].Open([]byte("plain")); err == nil {
+		t.Error("open a plain file with a cipher: want error")
+	}
+}
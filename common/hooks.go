@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Hook events.
+const (
+	HookBefore      = "before"
+	HookAfter       = "after"
+	HookBeforeTable = "before_table"
+	HookAfterTable  = "after_table"
+)
+
+// Hook kinds.
+const (
+	HookShell     = "sh"
+	HookSQL       = "sql"
+	HookTargetSQL = "target-sql"
+)
+
+// Hook tuple.
+type Hook struct {
+	Name    string
+	Kind    string
+	Command string
+	// IgnoreError used to log the failure instead of aborting the run, set by a leading '-'.
+	IgnoreError bool
+}
+
+// Hooks holds the hooks of a run by event.
+type Hooks struct {
+	events map[string][]Hook
+}
+
+// ReadHooks used to read the hooks config, a TOML file with the event as section,
+// the hooks of an event run in the order of their names:
+//
+//	[before]
+//	1-cron = "sh:systemctl stop cron"
+//
+//	[after_table]
+//	analyze = "-sql:ANALYZE TABLE `${MYSQLDUMP_TABLE}`"
+func ReadHooks(file string) (*Hooks, error) {
+	cfg, err := ReadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	h := &Hooks{events: map[string][]Hook{}}
+	for section, kvs := range cfg {
+		switch section {
+		case HookBefore, HookAfter, HookBeforeTable, HookAfterTable:
+		case "":
+			if len(kvs) == 0 {
+				continue
+			}
+			fallthrough
+		default:
+			return nil, fmt.Errorf("hooks section %q: expect [before], [after], [before_table] or [after_table]", section)
+		}
+		for name, value := range kvs {
+			hook, err := ParseHook(name, value)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s.%s: %v", section, name, err)
+			}
+			h.events[section] = append(h.events[section], hook)
+		}
+		hooks := h.events[section]
+		sort.Slice(hooks, func(i, j int) bool { return hooks[i].Name < hooks[j].Name })
+	}
+	return h, nil
+}
+
+// ParseHook used to parse '[-]kind:command'.
+func ParseHook(name, s string) (Hook, error) {
+	hook := Hook{Name: name}
+	if strings.HasPrefix(s, "-") {
+		hook.IgnoreError = true
+		s = s[1:]
+	}
+	kv := strings.SplitN(s, ":", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+		return hook, fmt.Errorf("expect 'sh:command', 'sql:statement' or 'target-sql:statement', got %q", s)
+	}
+	hook.Kind, hook.Command = strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+	switch hook.Kind {
+	case HookShell, HookSQL, HookTargetSQL:
+	default:
+		return hook, fmt.Errorf("unknown hook kind %q, expect sh, sql or target-sql", hook.Kind)
+	}
+	return hook, nil
+}
+
+// Event returns the hooks of the event, nil if there is none.
+func (h *Hooks) Event(event string) []Hook {
+	if h == nil {
+		return nil
+	}
+	return h.events[event]
+}
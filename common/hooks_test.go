@@ -0,0 +1,68 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseHook(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Hook
+		err  bool
+	}{
+		{"sh:systemctl stop cron", Hook{Name: "h", Kind: HookShell, Command: "systemctl stop cron"}, false},
+		{"-sql: ANALYZE TABLE t ", Hook{Name: "h", Kind: HookSQL, Command: "ANALYZE TABLE t", IgnoreError: true}, false},
+		{"TARGET-SQL:SELECT 1:2", Hook{Name: "h", Kind: HookTargetSQL, Command: "SELECT 1:2"}, false},
+		{"sql:", Hook{}, true},
+		{"echo hi", Hook{}, true},
+		{"py:print()", Hook{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHook("h", tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseHook(%q) error = %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("ParseHook(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestReadHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "hooks.toml")
+	data := "[before]\n2-b = \"sh:echo b\"\n1-a = \"sh:echo a\"\n\n[after_table]\nanalyze = \"-sql:ANALYZE TABLE `${MYSQLDUMP_TABLE}`\"\n"
+	if err := ioutil.WriteFile(file, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadHooks(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := h.Event(HookBefore)
+	if len(before) != 2 || before[0].Name != "1-a" || before[1].Name != "2-b" {
+		t.Errorf("before hooks = %+v, want 1-a then 2-b", before)
+	}
+	after := h.Event(HookAfterTable)
+	if len(after) != 1 || !after[0].IgnoreError || after[0].Command != "ANALYZE TABLE `${MYSQLDUMP_TABLE}`" {
+		t.Errorf("after_table hooks = %+v", after)
+	}
+	if h.Event(HookAfter) != nil {
+		t.Errorf("after hooks = %+v, want none", h.Event(HookAfter))
+	}
+
+	if err := ioutil.WriteFile(file, []byte("[during]\nx = \"sh:true\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHooks(file); err == nil {
+		t.Error("ReadHooks with an unknown section: want error")
+	}
+}
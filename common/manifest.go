@@ -0,0 +1,175 @@
+package common
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManifestName is the file name of the manifest in the dump directory.
+const ManifestName = "manifest.json"
+
+// ManifestFile tuple.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Table  string `json:"table,omitempty"`
+	Chunk  int    `json:"chunk,omitempty"`
+	Rows   uint64 `json:"rows,omitempty"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// TableChecksum tuple.
+type TableChecksum struct {
+	Rows     uint64 `json:"rows"`
+	Checksum string `json:"checksum"`
+}
+
+// Watermark is the max value of the watermark column of a table when it was dumped,
+// Value is empty if the table had no rows.
+type Watermark struct {
+	Column string `json:"column"`
+	Value  string `json:"value"`
+}
+
+// BinlogPosition is the binlog coordinates of the server when the dump started.
+type BinlogPosition struct {
+	File     string `json:"file"`
+	Position uint64 `json:"position"`
+	GTIDSet  string `json:"gtid_set,omitempty"`
+}
+
+// Manifest lists every file of a dump.
+type Manifest struct {
+	mu        sync.Mutex
+	Database  string                    `json:"database"`
+	CreatedAt time.Time                 `json:"created_at"`
+	Files     []*ManifestFile           `json:"files"`
+	Checksums map[string]*TableChecksum `json:"checksums,omitempty"`
+	// Watermarks are recorded by the tables with a watermark column.
+	Watermarks map[string]*Watermark `json:"watermarks,omitempty"`
+	// IncrementalFrom is the dump an incremental dump starts from, it's loaded with upserts.
+	IncrementalFrom string `json:"incremental_from,omitempty"`
+	// Binlog is where the binlog archive of the dump starts.
+	Binlog *BinlogPosition `json:"binlog,omitempty"`
+
+	index map[string]*ManifestFile
+}
+
+// NewManifest creates a new manifest.
+func NewManifest(database string) *Manifest {
+	return &Manifest{Database: database, CreatedAt: time.Now()}
+}
+
+// Checksum returns the hex sha256 of data.
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Add used to record the file with the size and checksum of data.
+func (m *Manifest) Add(f ManifestFile, data []byte) {
+	f.Size = int64(len(data))
+	f.Sha256 = Checksum(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Files = append(m.Files, &f)
+	m.index = nil
+}
+
+// SetChecksum used to record the row count and checksum of the table.
+func (m *Manifest) SetChecksum(table string, c *TableChecksum) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Checksums == nil {
+		m.Checksums = make(map[string]*TableChecksum)
+	}
+	m.Checksums[table] = c
+}
+
+// Lookup returns the file recorded with name.
+func (m *Manifest) Lookup(name string) (*ManifestFile, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.index == nil {
+		m.index = make(map[string]*ManifestFile, len(m.Files))
+		for _, f := range m.Files {
+			m.index[f.Name] = f
+		}
+	}
+	f, ok := m.index[name]
+	return f, ok
+}
+
+// Check used to compare data with the recorded file.
+func (m *Manifest) Check(name string, data []byte) error {
+	f, ok := m.Lookup(name)
+	if !ok {
+		return fmt.Errorf("%s not in manifest", name)
+	}
+	if f.Size != int64(len(data)) {
+		return fmt.Errorf("%s size mismatch, expect %d got %d", name, f.Size, len(data))
+	}
+	if sum := Checksum(data); sum != f.Sha256 {
+		return fmt.Errorf("%s sha256 mismatch, expect %s got %s", name, f.Sha256, sum)
+	}
+	return nil
+}
+
+// Write used to write the manifest to the storage, encrypted if c is not nil.
+func (m *Manifest) Write(s Storage, c *Cipher) error {
+	m.mu.Lock()
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Name < m.Files[j].Name })
+	data, err := json.MarshalIndent(m, "", "  ")
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		if data, err = c.Seal(data); err != nil {
+			return err
+		}
+	}
+	return s.Create(ManifestName, data)
+}
+
+// ReadManifest used to read the manifest from the storage, returns nil if not exists.
+func ReadManifest(s Storage, c *Cipher) (*Manifest, error) {
+	data, err := ReadAll(s, ManifestName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = c.Open(data); err != nil {
+		return nil, fmt.Errorf("%s: %v", ManifestName, err)
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Verify used to recheck all the files of the manifest in the storage.
+func (m *Manifest) Verify(s Storage) []error {
+	var errs []error
+	for _, f := range m.Files {
+		data, err := ReadAll(s, f.Name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := m.Check(f.Name, data); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
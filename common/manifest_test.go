@@ -0,0 +1,123 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestManifestCheck(t *testing.T) {
+	m := NewManifest("test")
+	m.Add(ManifestFile{Name: "t1.00001.sql", Kind: "data", Table: "t1", Chunk: 1, Rows: 2}, []byte("INSERT 1;\n"))
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"t1.00001.sql", "INSERT 1;\n", ""},
+		{"t1.00001.sql", "INSERT 2;\n", "t1.00001.sql sha256 mismatch"},
+		{"t1.00001.sql", "INSERT 1;", "t1.00001.sql size mismatch, expect 10 got 9"},
+		{"t2.00001.sql", "INSERT 1;\n", "t2.00001.sql not in manifest"},
+	}
+	for _, tt := range tests {
+		err := m.Check(tt.name, []byte(tt.data))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("Check(%s, %q) = %v, want %q", tt.name, tt.data, err, tt.err)
+		}
+	}
+	// the index is rebuilt after a file is added.
+	m.Add(ManifestFile{Name: "t2.00001.sql", Kind: "data", Table: "t2"}, nil)
+	if f, ok := m.Lookup("t2.00001.sql"); !ok || f.Size != 0 || f.Sha256 != Checksum(nil) {
+		t.Errorf("Lookup(t2.00001.sql) = %+v, %v", f, ok)
+	}
+}
+
+func TestManifestWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passphrase, err := NewPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []*Cipher{nil, passphrase} {
+		s := NewLocalStorage(dir)
+		if m, err := ReadManifest(s, c); m != nil || err != nil {
+			t.Fatalf("ReadManifest of a missing manifest = %v, %v", m, err)
+		}
+
+		files := map[string]string{"test-schema-create.sql": "CREATE DATABASE `test`;\n", "t1-schema.sql": "CREATE TABLE `t1` (`id` int);\n", "t1.00001.sql": "INSERT INTO `t1` VALUES (1);\n"}
+		m := NewManifest("test")
+		for _, name := range []string{"t1.00001.sql", "t1-schema.sql", "test-schema-create.sql"} {
+			if err := s.Create(name, []byte(files[name])); err != nil {
+				t.Fatal(err)
+			}
+			m.Add(ManifestFile{Name: name, Kind: "schema"}, []byte(files[name]))
+		}
+		m.SetChecksum("t1", &TableChecksum{Rows: 1, Checksum: "42"})
+		m.Watermarks = map[string]*Watermark{"t1": {Column: "id", Value: "1"}}
+		m.Binlog = &BinlogPosition{File: "binlog.000001", Position: 4}
+		if err := m.Write(s, c); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadManifest(s, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Database != "test" || !got.CreatedAt.Equal(m.CreatedAt) || !reflect.DeepEqual(got.Files, m.Files) ||
+			!reflect.DeepEqual(got.Checksums, m.Checksums) || !reflect.DeepEqual(got.Watermarks, m.Watermarks) || !reflect.DeepEqual(got.Binlog, m.Binlog) {
+			t.Errorf("ReadManifest = %+v, want %+v", got, m)
+		}
+		if got.Files[0].Name != "t1-schema.sql" {
+			t.Errorf("the files are not sorted: %s first", got.Files[0].Name)
+		}
+		if errs := got.Verify(s); len(errs) != 0 {
+			t.Errorf("Verify = %v", errs)
+		}
+
+		if err := s.Create("t1.00001.sql", []byte("INSERT INTO `t1` VALUES (2);\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Delete("t1-schema.sql"); err != nil {
+			t.Fatal(err)
+		}
+		if errs := got.Verify(s); len(errs) != 2 {
+			t.Errorf("Verify of a changed and a missing file = %v", errs)
+		}
+		if err := s.Delete(ManifestName); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadManifestCipher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c, err := NewPassphraseCipher("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewLocalStorage(dir)
+
+	if err := NewManifest("test").Write(s, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(s, nil); err == nil {
+		t.Errorf("ReadManifest of an encrypted manifest without the key succeeded")
+	}
+	if err := NewManifest("test").Write(s, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadManifest(s, c); err == nil {
+		t.Errorf("ReadManifest of a plain manifest with a key succeeded")
+	}
+}
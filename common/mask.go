@@ -0,0 +1,109 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Mask kinds.
+const (
+	MaskNull     = "null"
+	MaskFixed    = "fixed"
+	MaskHash     = "hash"
+	MaskEmail    = "email"
+	MaskPhone    = "phone"
+	MaskName     = "name"
+	MaskTruncate = "truncate"
+	MaskShuffle  = "shuffle"
+)
+
+// MaskRule tuple.
+type MaskRule struct {
+	Kind string
+	// Arg is the value of fixed, or the length of truncate.
+	Arg string
+	// Length is the parsed Arg of truncate.
+	Length int
+}
+
+// Masking holds the masking rules of a dump.
+type Masking struct {
+	Salt  string
+	rules map[string]map[string]MaskRule
+}
+
+// ReadMasking used to read the masking config, a TOML file with the table as section:
+//
+//	salt = "secret"
+//	orders.email = "email"
+//
+//	[users]
+//	email = "email"
+//	password = "null"
+//	status = "fixed:active"
+//	note = "truncate:10"
+func ReadMasking(file string) (*Masking, error) {
+	cfg, err := ReadConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	m := &Masking{Salt: cfg[""]["salt"], rules: map[string]map[string]MaskRule{}}
+	for section, kvs := range cfg {
+		for key, value := range kvs {
+			table, column := section, key
+			if section == "" {
+				if key == "salt" {
+					continue
+				}
+				i := strings.LastIndex(key, ".")
+				if i < 0 {
+					return nil, fmt.Errorf("mask %s: expect 'table.column' or a [table] section", key)
+				}
+				table, column = key[:i], key[i+1:]
+			}
+			rule, err := ParseMaskRule(value)
+			if err != nil {
+				return nil, fmt.Errorf("mask %s.%s: %v", table, column, err)
+			}
+			if m.rules[table] == nil {
+				m.rules[table] = map[string]MaskRule{}
+			}
+			m.rules[table][column] = rule
+		}
+	}
+	return m, nil
+}
+
+// ParseMaskRule used to parse 'kind' or 'kind:arg'.
+func ParseMaskRule(s string) (MaskRule, error) {
+	kv := strings.SplitN(s, ":", 2)
+	rule := MaskRule{Kind: strings.ToLower(strings.TrimSpace(kv[0]))}
+	if len(kv) == 2 {
+		rule.Arg = kv[1]
+	}
+	switch rule.Kind {
+	case MaskNull, MaskHash, MaskEmail, MaskPhone, MaskName, MaskShuffle:
+	case MaskFixed:
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("expect 'fixed:value'")
+		}
+	case MaskTruncate:
+		n, err := strconv.Atoi(rule.Arg)
+		if err != nil || n < 0 {
+			return rule, fmt.Errorf("expect 'truncate:length', got %q", s)
+		}
+		rule.Length = n
+	default:
+		return rule, fmt.Errorf("unknown mask %q, expect null, fixed, hash, email, phone, name, truncate or shuffle", rule.Kind)
+	}
+	return rule, nil
+}
+
+// Table returns the rules of the table by column, nil if there is none.
+func (m *Masking) Table(table string) map[string]MaskRule {
+	if m == nil {
+		return nil
+	}
+	return m.rules[table]
+}
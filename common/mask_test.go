@@ -0,0 +1,31 @@
+package common
+
+import "testing"
+
+func TestParseMaskRule(t *testing.T) {
+	tests := []struct {
+		s    string
+		want MaskRule
+		err  bool
+	}{
+		{"hash", MaskRule{Kind: MaskHash}, false},
+		{" Email ", MaskRule{Kind: MaskEmail}, false},
+		{"fixed:a:b", MaskRule{Kind: MaskFixed, Arg: "a:b"}, false},
+		{"fixed:", MaskRule{Kind: MaskFixed}, false},
+		{"truncate:10", MaskRule{Kind: MaskTruncate, Arg: "10", Length: 10}, false},
+		{"fixed", MaskRule{}, true},
+		{"truncate:-1", MaskRule{}, true},
+		{"truncate:x", MaskRule{}, true},
+		{"rot13", MaskRule{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMaskRule(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseMaskRule(%q) error = %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("ParseMaskRule(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultOptionFiles are read in order when no option file is given, the later ones win.
+var DefaultOptionFiles = []string{"/etc/my.cnf", "/etc/mysql/my.cnf", "~/.my.cnf"}
+
+// OptionFile holds the options of the my.cnf style files by section, the keys use '-' instead of '_'.
+type OptionFile map[string]map[string]string
+
+// ReadOptionFiles used to read the option files in order, the missing ones are skipped unless
+// mustExist, and the world-writable ones are skipped like the mysql client does.
+func ReadOptionFiles(files []string, mustExist bool) (OptionFile, error) {
+	opts := OptionFile{}
+	for _, file := range files {
+		if strings.HasPrefix(file, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				continue
+			}
+			file = filepath.Join(home, file[2:])
+		}
+		if err := opts.read(file, mustExist, 0); err != nil {
+			return nil, err
+		}
+	}
+	return opts, nil
+}
+
+func (o OptionFile) read(file string, mustExist bool, depth int) error {
+	if depth > 10 {
+		return fmt.Errorf("%s: too many nested includes", file)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		if os.IsNotExist(err) && !mustExist {
+			return nil
+		}
+		return err
+	}
+	if info.Mode().Perm()&0002 != 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	section := ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for no := 1; scanner.Scan(); no++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "!includedir "):
+			dir := strings.TrimSpace(line[len("!includedir "):])
+			names, err := filepath.Glob(filepath.Join(dir, "*.cnf"))
+			if err != nil {
+				return err
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := o.read(name, true, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		case strings.HasPrefix(line, "!include "):
+			if err := o.read(strings.TrimSpace(line[len("!include "):]), true, depth+1); err != nil {
+				return err
+			}
+			continue
+		case line[0] == '[':
+			if !strings.HasSuffix(line, "]") {
+				return fmt.Errorf("%s line %d: bad section %q", file, no, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section == "" {
+			return fmt.Errorf("%s line %d: option %q out of a section", file, no, line)
+		}
+
+		key, value := line, "true"
+		if i := strings.Index(line, "="); i >= 0 {
+			key, value = strings.TrimSpace(line[:i]), optionValue(strings.TrimSpace(line[i+1:]))
+		}
+		key = strings.Replace(key, "_", "-", -1)
+		if o[section] == nil {
+			o[section] = map[string]string{}
+		}
+		o[section][key] = value
+	}
+	return scanner.Err()
+}
+
+// optionValue used to unquote the value and strip its comment, the escapes are \b \t \n \r \\ \s.
+func optionValue(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') {
+		if end := strings.IndexByte(v[1:], v[0]); end >= 0 {
+			v = v[1 : end+1]
+		}
+	} else if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return strings.NewReplacer(`\b`, "\b", `\t`, "\t", `\n`, "\n", `\r`, "\r", `\\`, `\`, `\s`, " ").Replace(v)
+}
+
+// Lookup returns the value of key in the last of the sections having it.
+func (o OptionFile) Lookup(sections []string, key string) (string, bool) {
+	for i := len(sections) - 1; i >= 0; i-- {
+		if v, ok := o[sections[i]][key]; ok {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,94 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOptionValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"secret", "secret"},
+		{"secret # comment", "secret"},
+		{"se#cret", "se#cret"},
+		{`"se cret" # x`, "se cret"},
+		{`'a#b'`, "a#b"},
+		{`"a\sb\\c\td"`, "a b\\c\td"},
+		{`"unterminated`, `"unterminated`},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := optionValue(tt.in); got != tt.want {
+			t.Errorf("optionValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadOptionFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optionfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string, perm os.FileMode) string {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), perm); err != nil {
+			t.Fatal(err)
+		}
+		// the umask may have cleared the world-writable bit.
+		if err := os.Chmod(file, perm); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	write("conf.d/a.cnf", "[client]\nhost = a\n", 0644)
+	write("conf.d/b.cnf", "[client]\nhost = b\n", 0644)
+	write("conf.d/other.txt", "[client]\nhost = txt\n", 0644)
+	write("writable.cnf", "[client]\npassword = evil\n", 0666)
+	cnf := write("my.cnf", "# comment\n; comment\n[client]\nuser = root\npassword = \"s3cret\" # x\nssl_mode = REQUIRED\nenable-cleartext-plugin\n"+
+		"!includedir "+filepath.Join(dir, "conf.d")+"\n"+
+		"!include "+filepath.Join(dir, "writable.cnf")+"\n"+
+		"[mysqldump]\nuser = dumper\n", 0600)
+
+	opts, err := ReadOptionFiles([]string{filepath.Join(dir, "missing.cnf"), cnf}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := OptionFile{
+		"client":    {"user": "root", "password": "s3cret", "ssl-mode": "REQUIRED", "enable-cleartext-plugin": "true", "host": "b"},
+		"mysqldump": {"user": "dumper"},
+	}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("ReadOptionFiles = %v, want %v", opts, want)
+	}
+	sections := []string{"client", "mysqldump"}
+	for key, value := range map[string]string{"user": "dumper", "password": "s3cret", "host": "b"} {
+		if v, ok := opts.Lookup(sections, key); !ok || v != value {
+			t.Errorf("Lookup(%s) = %q, %v, want %q", key, v, ok, value)
+		}
+	}
+	if _, ok := opts.Lookup(sections, "socket"); ok {
+		t.Errorf("Lookup(socket) found a value")
+	}
+
+	if _, err := ReadOptionFiles([]string{filepath.Join(dir, "missing.cnf")}, true); err == nil {
+		t.Errorf("ReadOptionFiles of a missing file with mustExist succeeded")
+	}
+	for name, data := range map[string]string{
+		"nosection.cnf":  "user = root\n",
+		"badsection.cnf": "[client\n",
+		"loop.cnf":       "!include " + filepath.Join(dir, "loop.cnf") + "\n",
+	} {
+		file := write(name, data, 0600)
+		if _, err := ReadOptionFiles([]string{file}, false); err == nil {
+			t.Errorf("ReadOptionFiles(%s) succeeded", name)
+		}
+	}
+}
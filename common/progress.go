@@ -0,0 +1,42 @@
+package common
+
+import (
+	"time"
+)
+
+// Table status.
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusSkipped = "skipped"
+	StatusDone    = "done"
+)
+
+// TableProgress tuple.
+type TableProgress struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Bytes      uint64 `json:"bytes"`
+	Rows       uint64 `json:"rows"`
+	TotalBytes uint64 `json:"total_bytes"`
+	TotalRows  uint64 `json:"total_rows"`
+	Parts      int    `json:"parts,omitempty"`
+	PartsDone  int    `json:"parts_done,omitempty"`
+}
+
+// ProgressStatus is the snapshot emitted as json line or status file.
+type ProgressStatus struct {
+	Stage      string           `json:"stage"`
+	State      string           `json:"state"`
+	Database   string           `json:"database"`
+	StartTime  time.Time        `json:"start_time"`
+	ElapsedSec float64          `json:"elapsed_sec"`
+	Bytes      uint64           `json:"bytes"`
+	Rows       uint64           `json:"rows"`
+	TotalBytes uint64           `json:"total_bytes"`
+	TotalRows  uint64           `json:"total_rows"`
+	Percent    float64          `json:"percent"`
+	RateMB     float64          `json:"rate_mb_sec"`
+	EtaSec     float64          `json:"eta_sec"`
+	Tables     []*TableProgress `json:"tables,omitempty"`
+}
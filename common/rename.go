@@ -0,0 +1,406 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Renames holds the database and table rename rules of a restore, a nil Renames renames nothing.
+type Renames struct {
+	dbs    map[string]string
+	tables map[string][2]string
+	// byName finds the table rules by the table name, for statements without a current database.
+	byName map[string]string
+}
+
+// ParseRenames used to parse the rename rules, separated by ',':
+//
+//	olddb=newdb          renames the database
+//	db.t1=db.t1_restored renames the table, the new name may leave out the database
+func ParseRenames(spec string) (*Renames, error) {
+	r := &Renames{dbs: map[string]string{}, tables: map[string][2]string{}, byName: map[string]string{}}
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("rename rule %q: expect 'old=new'", rule)
+		}
+		from, to := splitName(kv[0]), splitName(kv[1])
+		switch {
+		case len(from) == 1 && len(to) == 1:
+			r.dbs[from[0]] = to[0]
+		case len(from) == 2 && len(to) == 1:
+			r.addTable(from[0], from[1], "", to[0])
+		case len(from) == 2 && len(to) == 2:
+			r.addTable(from[0], from[1], to[0], to[1])
+		default:
+			return nil, fmt.Errorf("rename rule %q: expect 'db=newdb' or 'db.table=[db.]newtable'", rule)
+		}
+	}
+	return r, nil
+}
+
+// splitName splits 'db.table' or '`db`.`table`' into its unquoted parts.
+func splitName(name string) []string {
+	parts := strings.Split(strings.TrimSpace(name), ".")
+	for i, p := range parts {
+		p = strings.TrimSpace(p)
+		if len(p) >= 2 && p[0] == '`' && p[len(p)-1] == '`' {
+			p = p[1 : len(p)-1]
+		}
+		if p == "" {
+			return nil
+		}
+		parts[i] = p
+	}
+	return parts
+}
+
+func (r *Renames) addTable(db, table, newDB, newTable string) {
+	r.tables[db+"\x00"+table] = [2]string{newDB, newTable}
+	r.byName[table] = db
+}
+
+// Empty returns true if there is no rule.
+func (r *Renames) Empty() bool {
+	return r == nil || (len(r.dbs) == 0 && len(r.tables) == 0)
+}
+
+// SetDefault used to rename db to newDB if there is no rule for db yet, it's how '-db' moves a dump.
+func (r *Renames) SetDefault(db, newDB string) {
+	if r == nil || db == "" || db == newDB {
+		return
+	}
+	if _, ok := r.dbs[db]; !ok {
+		r.dbs[db] = newDB
+	}
+}
+
+// Database returns the new name of the database.
+func (r *Renames) Database(db string) string {
+	if r == nil {
+		return db
+	}
+	if n, ok := r.dbs[db]; ok {
+		return n
+	}
+	return db
+}
+
+// IsDatabase returns true if a rule renames the database or one of its tables.
+func (r *Renames) IsDatabase(db string) bool {
+	if r == nil {
+		return false
+	}
+	if _, ok := r.dbs[db]; ok {
+		return true
+	}
+	for _, d := range r.byName {
+		if d == db {
+			return true
+		}
+	}
+	return false
+}
+
+// Table returns the new database and name of the table, db "" matches the rules of any database.
+func (r *Renames) Table(db, table string) (string, string) {
+	if r == nil {
+		return db, table
+	}
+	if db == "" {
+		if d, ok := r.byName[table]; ok {
+			db = d
+		}
+	}
+	if n, ok := r.tables[db+"\x00"+table]; ok {
+		if n[0] == "" {
+			return r.Database(db), n[1]
+		}
+		return n[0], n[1]
+	}
+	return r.Database(db), table
+}
+
+// Rewrite used to apply the rules to the identifiers of a statement, db is the database the statement runs in.
+// Quoted strings and comments are kept, the data of an INSERT is copied as is.
+func (r *Renames) Rewrite(stmt string, db string) string {
+	if r.Empty() {
+		return stmt
+	}
+	w := &rewriter{r: r, db: db, s: stmt}
+	return w.rewrite()
+}
+
+// tableWords are the words a table name follows.
+var tableWords = map[string]bool{
+	"FROM": true, "JOIN": true, "INTO": true, "UPDATE": true, "TABLE": true, "TABLES": true,
+	"REFERENCES": true, "VIEW": true,
+}
+
+// optionWords may stand between a table word and the table name.
+var optionWords = map[string]bool{
+	"IF": true, "NOT": true, "EXISTS": true, "IGNORE": true, "LOW_PRIORITY": true, "DELAYED": true,
+	"HIGH_PRIORITY": true, "TEMPORARY": true,
+}
+
+// listEnds are the words ending a FROM list.
+var listEnds = map[string]bool{
+	"WHERE": true, "GROUP": true, "HAVING": true, "ORDER": true, "LIMIT": true, "ON": true,
+	"USING": true, "UNION": true, "SET": true, "WINDOW": true, "FOR": true,
+}
+
+type ident struct {
+	name   string
+	quoted bool
+}
+
+type rewriter struct {
+	r      *Renames
+	db     string
+	s      string
+	i      int
+	out    strings.Builder
+	prev   string
+	prev2  string
+	inList bool
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (w *rewriter) word(v string) {
+	w.prev2, w.prev = w.prev, v
+}
+
+func (w *rewriter) rewrite() string {
+	s := w.s
+	insert := false
+	for w.i < len(s) {
+		c := s[w.i]
+		switch {
+		case c == '\'' || c == '"':
+			w.copyQuoted(c)
+			w.word("'")
+		case c == '#' || (c == '-' && strings.HasPrefix(s[w.i:], "-- ")):
+			end := strings.IndexByte(s[w.i:], '\n')
+			if end < 0 {
+				end = len(s) - w.i
+			}
+			w.out.WriteString(s[w.i : w.i+end])
+			w.i += end
+		case c == '/' && strings.HasPrefix(s[w.i:], "/*!"):
+			// the content of executable comments is code.
+			j := w.i + 3
+			for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+				j++
+			}
+			w.out.WriteString(s[w.i:j])
+			w.i = j
+		case c == '/' && strings.HasPrefix(s[w.i:], "/*"):
+			end := strings.Index(s[w.i+2:], "*/")
+			if end < 0 {
+				end = len(s) - w.i - 2
+			} else {
+				end += 2
+			}
+			w.out.WriteString(s[w.i : w.i+2+end])
+			w.i += 2 + end
+		case c == '*' && strings.HasPrefix(s[w.i:], "*/"):
+			w.out.WriteString("*/")
+			w.i += 2
+		case c >= '0' && c <= '9':
+			j := w.i
+			for j < len(s) && (isWordByte(s[j]) || s[j] == '.') {
+				j++
+			}
+			w.out.WriteString(s[w.i:j])
+			w.i = j
+			w.word("0")
+		case c == '`' || isWordByte(c):
+			start := w.i
+			parts := w.name()
+			if len(parts) == 1 && !parts[0].quoted {
+				upper := strings.ToUpper(parts[0].name)
+				if w.prev == "" && (upper == "INSERT" || upper == "REPLACE") {
+					insert = true
+				}
+				if insert && (upper == "VALUES" || upper == "VALUE") {
+					w.out.WriteString(s[start:])
+					return w.out.String()
+				}
+				if optionWords[upper] {
+					w.out.WriteString(s[start:w.i])
+					continue
+				}
+				if !w.tablePosition() && w.prev != "USE" && w.prev != "DATABASE" && w.prev != "SCHEMA" {
+					w.out.WriteString(s[start:w.i])
+					switch {
+					case upper == "FROM" || upper == "JOIN":
+						w.inList = true
+					case listEnds[upper]:
+						w.inList = false
+					}
+					w.word(upper)
+					continue
+				}
+			}
+			if out, ok := w.rename(parts); ok {
+				w.out.WriteString(out)
+			} else {
+				w.out.WriteString(s[start:w.i])
+			}
+			w.word("`")
+		default:
+			w.out.WriteByte(c)
+			w.i++
+			switch c {
+			case ' ', '\t', '\r', '\n', '*':
+			case ')':
+				w.inList = false
+				w.word(")")
+			default:
+				w.word(string(c))
+			}
+		}
+	}
+	return w.out.String()
+}
+
+// tablePosition returns true if the next name is a table.
+func (w *rewriter) tablePosition() bool {
+	if tableWords[w.prev] || (w.prev == "," && w.inList) {
+		return true
+	}
+	// CREATE TRIGGER ... BEFORE INSERT ON t
+	return w.prev == "ON" && (w.prev2 == "INSERT" || w.prev2 == "UPDATE" || w.prev2 == "DELETE")
+}
+
+func (w *rewriter) copyQuoted(q byte) {
+	s := w.s
+	j := w.i + 1
+	for j < len(s) {
+		if s[j] == '\\' {
+			j += 2
+			continue
+		}
+		if s[j] == q {
+			if j+1 < len(s) && s[j+1] == q {
+				j += 2
+				continue
+			}
+			j++
+			break
+		}
+		j++
+	}
+	if j > len(s) {
+		j = len(s)
+	}
+	w.out.WriteString(s[w.i:j])
+	w.i = j
+}
+
+// name reads a name of one to three parts like db.table.column.
+func (w *rewriter) name() []ident {
+	s := w.s
+	var parts []ident
+	for {
+		if w.i >= len(s) {
+			return parts
+		}
+		if s[w.i] == '`' {
+			j := w.i + 1
+			for j < len(s) {
+				if s[j] == '`' {
+					if j+1 < len(s) && s[j+1] == '`' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			parts = append(parts, ident{name: strings.Replace(s[w.i+1:min(j, len(s))], "``", "`", -1), quoted: true})
+			w.i = min(j+1, len(s))
+		} else {
+			j := w.i
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			if j == w.i {
+				return parts
+			}
+			parts = append(parts, ident{name: s[w.i:j]})
+			w.i = j
+		}
+		// a '.' followed by another part.
+		j := w.i
+		for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+			j++
+		}
+		if j >= len(s) || s[j] != '.' || len(parts) == 3 {
+			return parts
+		}
+		j++
+		for j < len(s) && (s[j] == ' ' || s[j] == '\n') {
+			j++
+		}
+		if j >= len(s) || (s[j] != '`' && !isWordByte(s[j])) {
+			return parts
+		}
+		w.i = j
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// rename returns the renamed name, or false if nothing changed.
+func (w *rewriter) rename(parts []ident) (string, bool) {
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		names[i] = p.name
+	}
+	renamed := append([]string(nil), names...)
+	switch {
+	case len(parts) == 1 && (w.prev == "USE" || w.prev == "DATABASE" || w.prev == "SCHEMA"):
+		renamed[0] = w.r.Database(names[0])
+	case len(parts) == 1 && w.tablePosition():
+		_, renamed[0] = w.r.Table(w.db, names[0])
+	case len(parts) == 1:
+		// a column or an alias.
+	case len(parts) == 3 || w.tablePosition() || w.r.IsDatabase(names[0]):
+		// db.table(.column)
+		renamed[0], renamed[1] = w.r.Table(names[0], names[1])
+	default:
+		// table.column
+		_, renamed[0] = w.r.Table(w.db, names[0])
+	}
+
+	changed := false
+	for i := range names {
+		if names[i] != renamed[i] {
+			changed = true
+		}
+	}
+	if !changed {
+		return "", false
+	}
+	for i := range renamed {
+		renamed[i] = quoteIdent(renamed[i])
+	}
+	return strings.Join(renamed, "."), true
+}
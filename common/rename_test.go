@@ -0,0 +1,110 @@
+package common
+
+import "testing"
+
+// TestRenamesCopy covers how a copy moves the schema of the source database to the target one.
+func TestRenamesCopy(t *testing.T) {
+	r, err := ParseRenames("src.t2=t2_copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetDefault("src", "dst")
+	tests := []struct {
+		stmt string
+		want string
+	}{
+		{
+			"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v` AS select `src`.`t1`.`id` AS `id` from (`src`.`t1` join `src`.`t2` on((`src`.`t1`.`id` = `src`.`t2`.`id`)))",
+			"CREATE ALGORITHM=UNDEFINED DEFINER=`root`@`%` SQL SECURITY DEFINER VIEW `v` AS select `dst`.`t1`.`id` AS `id` from (`dst`.`t1` join `dst`.`t2_copy` on((`dst`.`t1`.`id` = `dst`.`t2_copy`.`id`)))",
+		},
+		{
+			"CREATE DEFINER=`root`@`%` PROCEDURE `p`()\nBEGIN\n  INSERT INTO `src`.`t1` SELECT * FROM t2 WHERE name = 'src.t2';\nEND",
+			"CREATE DEFINER=`root`@`%` PROCEDURE `p`()\nBEGIN\n  INSERT INTO `dst`.`t1` SELECT * FROM `t2_copy` WHERE name = 'src.t2';\nEND",
+		},
+		{"INSERT INTO `t2` VALUES (1,'src.t2')", "INSERT INTO `t2_copy` VALUES (1,'src.t2')"},
+	}
+	for _, tt := range tests {
+		if got := r.Rewrite(tt.stmt, "src"); got != tt.want {
+			t.Errorf("Rewrite(%q)\n got %q\nwant %q", tt.stmt, got, tt.want)
+		}
+	}
+}
+
+func TestParseRenames(t *testing.T) {
+	r, err := ParseRenames(" olddb = newdb ,`db`.`t1`=t1_restored,db.t2=other.t2, ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := []struct {
+		db, table     string
+		wantDB, wantT string
+	}{
+		{"db", "t1", "db", "t1_restored"},
+		{"", "t1", "db", "t1_restored"},
+		{"db", "t2", "other", "t2"},
+		{"db", "t3", "db", "t3"},
+		{"olddb", "t1", "newdb", "t1"},
+		{"x", "y", "x", "y"},
+	}
+	for _, tt := range tables {
+		if db, table := r.Table(tt.db, tt.table); db != tt.wantDB || table != tt.wantT {
+			t.Errorf("Table(%s, %s) = %s, %s, want %s, %s", tt.db, tt.table, db, table, tt.wantDB, tt.wantT)
+		}
+	}
+	if got := r.Database("olddb"); got != "newdb" {
+		t.Errorf("Database(olddb) = %s", got)
+	}
+	if !r.IsDatabase("db") || !r.IsDatabase("olddb") || r.IsDatabase("newdb") {
+		t.Errorf("IsDatabase is wrong")
+	}
+
+	r.SetDefault("olddb", "ignored")
+	r.SetDefault("db", "dst")
+	if r.Database("olddb") != "newdb" || r.Database("db") != "dst" {
+		t.Errorf("SetDefault overrode a rule or was ignored")
+	}
+	if db, table := r.Table("db", "t1"); db != "dst" || table != "t1_restored" {
+		t.Errorf("Table(db, t1) = %s, %s after SetDefault", db, table)
+	}
+
+	var none *Renames
+	if !none.Empty() || none.Rewrite("SELECT 1", "db") != "SELECT 1" || none.Database("db") != "db" {
+		t.Errorf("nil Renames renames")
+	}
+	for _, spec := range []string{"db", "a=b=c.d.e", "a.b.c=d", "a=b.c", "=b", "a.=b"} {
+		if _, err := ParseRenames(spec); err == nil {
+			t.Errorf("ParseRenames(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRenamesRewrite(t *testing.T) {
+	r, err := ParseRenames("src=dst,src.t1=t1_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		stmt string
+		want string
+	}{
+		{"USE `src`", "USE `dst`"},
+		{"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `src` /*!40100 DEFAULT CHARACTER SET utf8mb4 */", "CREATE DATABASE /*!32312 IF NOT EXISTS*/ `dst` /*!40100 DEFAULT CHARACTER SET utf8mb4 */"},
+		{"DROP TABLE IF EXISTS `t1`", "DROP TABLE IF EXISTS `t1_new`"},
+		{"CREATE TABLE `t1` (\n  `t1` int,\n  KEY `t1` (`t1`)\n)", "CREATE TABLE `t1_new` (\n  `t1` int,\n  KEY `t1` (`t1`)\n)"},
+		{"CREATE TABLE t2 (a int, FOREIGN KEY (a) REFERENCES t1 (id))", "CREATE TABLE t2 (a int, FOREIGN KEY (a) REFERENCES `t1_new` (id))"},
+		{"INSERT INTO `t1` VALUES (1,'t1'),(2,'src.t1')", "INSERT INTO `t1_new` VALUES (1,'t1'),(2,'src.t1')"},
+		{"INSERT IGNORE INTO t1 (`t1`) VALUES (1)", "INSERT IGNORE INTO `t1_new` (`t1`) VALUES (1)"},
+		{"SELECT t1.a, t2.b FROM t1, t2 WHERE t1.a = 't1' -- t1\n", "SELECT `t1_new`.`a`, t2.b FROM `t1_new`, t2 WHERE `t1_new`.`a` = 't1' -- t1\n"},
+		{"SELECT * FROM src.t1 JOIN `src` . `t2` ON src.t1.id = t2.id", "SELECT * FROM `dst`.`t1_new` JOIN `dst`.`t2` ON `dst`.`t1_new`.`id` = t2.id"},
+		{"SELECT * FROM other.t1 /* src.t1 */", "SELECT * FROM other.t1 /* src.t1 */"},
+		{"CREATE TRIGGER tr BEFORE INSERT ON t1 FOR EACH ROW SET NEW.a = 1", "CREATE TRIGGER tr BEFORE INSERT ON `t1_new` FOR EACH ROW SET NEW.a = 1"},
+		{"/*!50001 CREATE VIEW `v` AS select `src`.`t1`.`a` AS `a` from `src`.`t1` */", "/*!50001 CREATE VIEW `v` AS select `dst`.`t1_new`.`a` AS `a` from `dst`.`t1_new` */"},
+		{"UPDATE t1 SET a = 1", "UPDATE `t1_new` SET a = 1"},
+		{"SELECT 1e3, 'it''s t1', \"t1\" FROM dual", "SELECT 1e3, 'it''s t1', \"t1\" FROM dual"},
+	}
+	for _, tt := range tests {
+		if got := r.Rewrite(tt.stmt, "src"); got != tt.want {
+			t.Errorf("Rewrite(%q)\n got %q\nwant %q", tt.stmt, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package common
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// s3MinPartSize is the smallest part of a multipart upload but the last one.
+	s3MinPartSize = 5 * 1024 * 1024
+	// s3MaxParts is the most parts of a multipart upload, the part size grows every 1000 parts to reach it later.
+	s3MaxParts  = 10000
+	s3Retries   = 3
+	emptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Config holds the endpoint and credentials of the S3-compatible storage.
+type S3Config struct {
+	// Endpoint like 'http://127.0.0.1:9000' of MinIO, addressed in path style,
+	// AWS is addressed in virtual hosted style if empty.
+	Endpoint     string
+	Region       string
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// PartSize of the multipart uploads in bytes.
+	PartSize int
+}
+
+// S3Storage keeps the files under a prefix of a bucket, signed by AWS Signature Version 4.
+type S3Storage struct {
+	cfg       S3Config
+	bucket    string
+	prefix    string
+	base      *url.URL
+	pathStyle bool
+	client    *http.Client
+}
+
+// NewS3Storage creates the storage of the location 's3://bucket/prefix'.
+func NewS3Storage(location string, cfg *S3Config) (*S3Storage, error) {
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("%s: no bucket", location)
+	}
+	if cfg == nil || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("%s: no credentials, set '-s3-access-key' and '-s3-secret-key', or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", location)
+	}
+	s := &S3Storage{cfg: *cfg, bucket: parts[0], client: &http.Client{}}
+	if len(parts) == 2 {
+		s.prefix = strings.Trim(parts[1], "/")
+	}
+	if s.cfg.Region == "" {
+		s.cfg.Region = "us-east-1"
+	}
+	if s.cfg.PartSize < s3MinPartSize {
+		s.cfg.PartSize = 16 * 1024 * 1024
+	}
+
+	endpoint := s.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s.bucket, s.cfg.Region)
+	} else {
+		s.pathStyle = true
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3 endpoint %s: %v", endpoint, err)
+	}
+	s.base = base
+	return s, nil
+}
+
+func (s *S3Storage) String() string {
+	if s.prefix == "" {
+		return "s3://" + s.bucket
+	}
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *S3Storage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+// s3Error is the error body of S3.
+type s3Error struct {
+	Code    string
+	Message string
+}
+
+type s3ListResult struct {
+	Contents []struct {
+		Key  string
+		Size int64
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+type s3InitiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3Part struct {
+	PartNumber int
+	ETag       string
+}
+
+type s3CompleteUpload struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []s3Part `xml:"Part"`
+}
+
+// uriEncode used to encode as the canonical request of SigV4, '/' is kept in paths.
+func uriEncode(s string, path bool) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			buf.WriteByte(c)
+		case c == '/' && path:
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			pairs = append(pairs, uriEncode(k, false)+"="+uriEncode(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign used to add the SigV4 authorization of the request.
+func (s *S3Storage) sign(req *http.Request, payload string, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	names := []string{"host"}
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k = strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			names = append(names, k)
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	request := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, canonicalHeaders.String(), signed, payload}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date[:8], s.cfg.Region)
+	toSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + Checksum([]byte(request))
+
+	key := hmacSha256([]byte("AWS4"+s.cfg.SecretKey), date[:8])
+	key = hmacSha256(key, s.cfg.Region)
+	key = hmacSha256(key, "s3")
+	key = hmacSha256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signed, hex.EncodeToString(hmacSha256(key, toSign))))
+}
+
+// do used to send the signed request of the object key, the requests failed by the network
+// or a 5xx are sent again. The error satisfies os.IsNotExist on 404.
+func (s *S3Storage) do(method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	p := "/" + key
+	if s.pathStyle {
+		p = "/" + s.bucket + strings.TrimSuffix(p, "/")
+	}
+	u := *s.base
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+	payload := emptySha256
+	if body != nil {
+		payload = Checksum(body)
+	}
+
+	var err error
+	for i := 0; i < s3Retries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * time.Second)
+		}
+		var req *http.Request
+		if req, err = http.NewRequest(method, u.String(), bytes.NewReader(body)); err != nil {
+			return nil, err
+		}
+		req.ContentLength = int64(len(body))
+		s.sign(req, payload, time.Now())
+
+		var resp *http.Response
+		if resp, err = s.client.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound && method != http.MethodDelete {
+			return nil, notExist(strings.ToLower(method), "s3://"+s.bucket+"/"+key)
+		}
+		err = responseError(method, key, resp.Status, data)
+		if resp.StatusCode < 500 {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func responseError(method string, key string, status string, data []byte) error {
+	e := &s3Error{}
+	if xml.Unmarshal(data, e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s %s: %s", method, key, status, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s %s: %s", method, key, status)
+}
+
+// call used to send the request and decode the xml response into v if not nil.
+func (s *S3Storage) call(method string, key string, query url.Values, body []byte, v interface{}) error {
+	resp, err := s.do(method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// CompleteMultipartUpload may fail with a 200.
+	if bytes.Contains(data, []byte("<Error>")) {
+		return responseError(method, key, resp.Status, data)
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// Create used to put the object.
+func (s *S3Storage) Create(name string, data []byte) error {
+	return s.call(http.MethodPut, s.key(name), nil, data, nil)
+}
+
+// Stream returns the writer uploading the object in parts while it's written,
+// the object is only visible after Close.
+func (s *S3Storage) Stream(name string) (io.WriteCloser, error) {
+	return &s3Writer{s: s, key: s.key(name)}, nil
+}
+
+// List returns the objects under the prefix.
+func (s *S3Storage) List(prefix string) ([]StorageFile, error) {
+	root := s.key("")
+	var files []StorageFile
+	query := url.Values{"list-type": {"2"}, "prefix": {root + prefix}}
+	for {
+		result := &s3ListResult{}
+		if err := s.call(http.MethodGet, "", query, nil, result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			files = append(files, StorageFile{Name: strings.TrimPrefix(c.Key, root), Size: c.Size})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Open returns the body of the object.
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, s.key(name), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete used to remove the object.
+func (s *S3Storage) Delete(name string) error {
+	return s.call(http.MethodDelete, s.key(name), nil, nil, nil)
+}
+
+// s3Writer buffers a part and uploads it while the next one is written,
+// an object smaller than a part is put at once.
+type s3Writer struct {
+	s        *S3Storage
+	key      string
+	buf      []byte
+	uploadID string
+	parts    []s3Part
+	// pending is the result of the part being uploaded.
+	pending chan error
+	err     error
+}
+
+func (w *s3Writer) partSize() int {
+	return w.s.cfg.PartSize * (1 + len(w.parts)/1000)
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.partSize() {
+		if w.err = w.flush(); w.err != nil {
+			w.abort()
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// wait returns the result of the pending upload.
+func (w *s3Writer) wait() error {
+	if w.pending == nil {
+		return nil
+	}
+	err := <-w.pending
+	w.pending = nil
+	return err
+}
+
+// flush used to start the upload of the buffered part.
+func (w *s3Writer) flush() error {
+	if err := w.wait(); err != nil {
+		return err
+	}
+	if w.uploadID == "" {
+		result := &s3InitiateResult{}
+		if err := w.s.call(http.MethodPost, w.key, url.Values{"uploads": {""}}, nil, result); err != nil {
+			return err
+		}
+		w.uploadID = result.UploadID
+	}
+	if len(w.parts) >= s3MaxParts {
+		return fmt.Errorf("s3 %s: more than %d parts", w.key, s3MaxParts)
+	}
+
+	part := len(w.parts)
+	w.parts = append(w.parts, s3Part{PartNumber: part + 1})
+	data := w.buf
+	w.buf = nil
+	w.pending = make(chan error, 1)
+	go func(pending chan error) {
+		query := url.Values{"partNumber": {fmt.Sprint(part + 1)}, "uploadId": {w.uploadID}}
+		resp, err := w.s.do(http.MethodPut, w.key, query, data)
+		if err == nil {
+			resp.Body.Close()
+			w.parts[part].ETag = resp.Header.Get("ETag")
+		}
+		pending <- err
+	}(w.pending)
+	return nil
+}
+
+func (w *s3Writer) abort() {
+	_ = w.wait()
+	if w.uploadID != "" {
+		_ = w.s.call(http.MethodDelete, w.key, url.Values{"uploadId": {w.uploadID}}, nil, nil)
+	}
+}
+
+// Close used to upload the rest and complete the object.
+func (w *s3Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.uploadID == "" && w.pending == nil {
+		w.err = w.s.call(http.MethodPut, w.key, nil, w.buf, nil)
+		return w.err
+	}
+	if len(w.buf) > 0 {
+		if w.err = w.flush(); w.err != nil {
+			w.abort()
+			return w.err
+		}
+	}
+	if w.err = w.wait(); w.err != nil {
+		w.abort()
+		return w.err
+	}
+	body, err := xml.Marshal(&s3CompleteUpload{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	if w.err = w.s.call(http.MethodPost, w.key, url.Values{"uploadId": {w.uploadID}}, body, nil); w.err != nil {
+		w.abort()
+		return w.err
+	}
+	w.err = errors.New("s3 writer is closed")
+	return nil
+}
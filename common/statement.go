@@ -0,0 +1,181 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// StatementReader used to split a sql stream into statements, it knows about quotes,
+// comments and the DELIMITER command of the mysql client.
+type StatementReader struct {
+	r         *bufio.Reader
+	delimiter string
+	n         uint64
+}
+
+// NewStatementReader creates a new StatementReader.
+func NewStatementReader(r io.Reader) *StatementReader {
+	return &StatementReader{r: bufio.NewReaderSize(r, 1<<20), delimiter: ";"}
+}
+
+// BytesRead returns the bytes consumed from the stream.
+func (s *StatementReader) BytesRead() uint64 {
+	return s.n
+}
+
+func (s *StatementReader) readByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err == nil {
+		s.n++
+	}
+	return c, err
+}
+
+// Next returns the next statement without the delimiter, comments only statements are skipped.
+// It returns io.EOF when there are no more statements.
+func (s *StatementReader) Next() (string, error) {
+	var buf bytes.Buffer
+	var quote byte
+	// code is the offset of the first byte which is not whitespace or a comment, -1 if none yet.
+	code := -1
+	hasCode := func() bool { return code >= 0 && code < buf.Len() }
+
+	for {
+		c, err := s.readByte()
+		if err == io.EOF {
+			if hasCode() {
+				return strings.TrimSpace(buf.String()), nil
+			}
+			return "", io.EOF
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if quote != 0 {
+			buf.WriteByte(c)
+			switch c {
+			case '\\':
+				if quote != '`' {
+					if n, err := s.readByte(); err == nil {
+						buf.WriteByte(n)
+					}
+				}
+			case quote:
+				quote = 0
+			}
+			continue
+		}
+
+		// the DELIMITER command only counts at the start of a statement.
+		if !hasCode() && (c == 'D' || c == 'd') {
+			if line, ok := s.delimiterCommand(c); ok {
+				s.delimiter = line
+				buf.Reset()
+				continue
+			}
+		}
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			if !hasCode() {
+				code = buf.Len()
+			}
+			buf.WriteByte(c)
+		case c == '#' || (c == '-' && s.dashComment()):
+			if err := s.skipLine(); err != nil && err != io.EOF {
+				return "", err
+			}
+			buf.WriteByte('\n')
+		case c == '/' && s.nextIs('*'):
+			comment, err := s.blockComment()
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			// executable comments /*! ... */ are statements of their own.
+			if strings.HasPrefix(comment, "/*!") && !hasCode() {
+				code = buf.Len()
+			}
+			buf.WriteString(comment)
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' && !hasCode() {
+				code = buf.Len()
+			}
+			buf.WriteByte(c)
+			// the delimiter itself is not code, a statement of comments only ends up empty.
+			if c == s.delimiter[len(s.delimiter)-1] && bytes.HasSuffix(buf.Bytes(), []byte(s.delimiter)) {
+				buf.Truncate(buf.Len() - len(s.delimiter))
+				if hasCode() {
+					return strings.TrimSpace(buf.String()), nil
+				}
+				buf.Reset()
+				code = -1
+			}
+		}
+	}
+}
+
+func (s *StatementReader) nextIs(c byte) bool {
+	b, err := s.r.Peek(1)
+	return err == nil && b[0] == c
+}
+
+// dashComment checks if the '-' just read starts a '-- ' comment.
+func (s *StatementReader) dashComment() bool {
+	b, _ := s.r.Peek(2)
+	if len(b) == 0 || b[0] != '-' {
+		return false
+	}
+	return len(b) == 1 || b[1] == ' ' || b[1] == '\t' || b[1] == '\n' || b[1] == '\r'
+}
+
+func (s *StatementReader) skipLine() error {
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return err
+		}
+		if c == '\n' {
+			return nil
+		}
+	}
+}
+
+func (s *StatementReader) blockComment() (string, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('/')
+	var prev byte
+	for {
+		c, err := s.readByte()
+		if err != nil {
+			return buf.String(), err
+		}
+		buf.WriteByte(c)
+		if prev == '*' && c == '/' && buf.Len() > 3 {
+			return buf.String(), nil
+		}
+		prev = c
+	}
+}
+
+// delimiterCommand checks if the line starting with c is a DELIMITER command and returns the new delimiter.
+func (s *StatementReader) delimiterCommand(c byte) (string, bool) {
+	const keyword = "DELIMITER "
+	b, err := s.r.Peek(len(keyword) - 1)
+	if err != nil || !strings.EqualFold(string(c)+string(b), keyword) {
+		return "", false
+	}
+	line, err := s.r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false
+	}
+	s.n += uint64(len(line))
+	delimiter := strings.TrimSpace(line[len(keyword)-1:])
+	if delimiter == "" {
+		return "", false
+	}
+	return delimiter, true
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStatementReader(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{"simple", "SELECT 1;\nSELECT 2;", []string{"SELECT 1", "SELECT 2"}},
+		{"no delimiter at the end", "SELECT 1;\n  SELECT 2  \n", []string{"SELECT 1", "SELECT 2"}},
+		{"delimiter in strings", `INSERT INTO t VALUES ('a;b',"c;d",` + "`e;f`" + `);`, []string{`INSERT INTO t VALUES ('a;b',"c;d",` + "`e;f`" + `)`}},
+		{"escaped quotes", `INSERT INTO t VALUES ('it\'s;','it''s;',"\";");SELECT 1;`, []string{`INSERT INTO t VALUES ('it\'s;','it''s;',"\";")`, "SELECT 1"}},
+		{"backslash in backquotes", "SELECT 1 AS `a\\`;SELECT 2;", []string{"SELECT 1 AS `a\\`", "SELECT 2"}},
+		{"line comments", "-- Dump of test;\n# host;\nSELECT 1; -- done;\n", []string{"SELECT 1"}},
+		{"dashes without space", "SELECT 1--1;", []string{"SELECT 1--1"}},
+		{"dash comment at the end", "SELECT 1;\n--", []string{"SELECT 1"}},
+		{"block comments only", "/* header; */\n;\n/*/ still; */SELECT 1;", []string{"/*/ still; */SELECT 1"}},
+		{"executable comments", "/*!40101 SET NAMES utf8mb4 */;\n/*!40014 SET FOREIGN_KEY_CHECKS=0 */;", []string{"/*!40101 SET NAMES utf8mb4 */", "/*!40014 SET FOREIGN_KEY_CHECKS=0 */"}},
+		{"empty statements", ";;\n;SELECT 1;;", []string{"SELECT 1"}},
+		{
+			"delimiter command",
+			"DELIMITER ;;\nCREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; SET NEW.b = 2; END ;;\ndelimiter ;\nSELECT 1;",
+			[]string{"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1; SET NEW.b = 2; END", "SELECT 1"},
+		},
+		{
+			"delimiter of many characters",
+			"DELIMITER $$\nCREATE PROCEDURE p() BEGIN SELECT '$$'; END$$\nDELIMITER ;\n",
+			[]string{"CREATE PROCEDURE p() BEGIN SELECT '$$'; END"},
+		},
+		{"comments only with a delimiter of many characters", "DELIMITER $$\n/* c */ $$\n-- d\n$$ SELECT 1$$", []string{"SELECT 1"}},
+		{"DELETE is not a delimiter command", "DELETE FROM t;DELIMITERS;", []string{"DELETE FROM t", "DELIMITERS"}},
+	}
+	for _, tt := range tests {
+		r := NewStatementReader(strings.NewReader(tt.sql))
+		var got []string
+		for {
+			stmt, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			got = append(got, stmt)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+		if r.BytesRead() != uint64(len(tt.sql)) {
+			t.Errorf("%s: read %d bytes, want %d", tt.name, r.BytesRead(), len(tt.sql))
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StorageFile is a file of the storage, Name is relative to the storage root and '/' separated.
+type StorageFile struct {
+	Name string
+	Size int64
+}
+
+// Storage is where the dump files are kept, a local directory or a bucket prefix.
+type Storage interface {
+	// Create used to write the whole file.
+	Create(name string, data []byte) error
+	// Stream returns a writer of the file, the file is complete after Close.
+	Stream(name string) (io.WriteCloser, error)
+	// List returns the files under prefix, sorted by name.
+	List(prefix string) ([]StorageFile, error)
+	// Open returns the reader of the file, the error satisfies os.IsNotExist if there is no such file.
+	Open(name string) (io.ReadCloser, error)
+	// Delete used to remove the file.
+	Delete(name string) error
+	// String returns the location of the storage.
+	String() string
+}
+
+// NewStorage returns the storage of the location, 's3://bucket/prefix' or a local directory.
+func NewStorage(location string, s3 *S3Config) (Storage, error) {
+	if IsS3(location) {
+		return NewS3Storage(location, s3)
+	}
+	return NewLocalStorage(location), nil
+}
+
+// IsS3 returns true if the location is in a bucket.
+func IsS3(location string) bool {
+	return strings.HasPrefix(location, "s3://")
+}
+
+// SplitLocation returns the storage location and the file name of a single file location.
+func SplitLocation(location string) (string, string) {
+	if IsS3(location) {
+		dir, name := path.Split(strings.TrimPrefix(location, "s3://"))
+		return "s3://" + strings.TrimSuffix(dir, "/"), name
+	}
+	return filepath.Dir(location), filepath.Base(location)
+}
+
+// ReadAll used to read the whole file of the storage.
+func ReadAll(s Storage, name string) ([]byte, error) {
+	r, err := s.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// LocalStorage keeps the files in a local directory.
+type LocalStorage struct {
+	dir string
+}
+
+// NewLocalStorage creates the storage of the directory.
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{dir: dir}
+}
+
+func (s *LocalStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// Create used to write the file, the parent directories are created if needed.
+func (s *LocalStorage) Create(name string, data []byte) error {
+	file := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return err
+	}
+	return WriteFile(file, BytesToString(data))
+}
+
+// Stream returns the file to write.
+func (s *LocalStorage) Stream(name string) (io.WriteCloser, error) {
+	file := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+		return nil, err
+	}
+	return os.Create(file)
+}
+
+// List returns the files under prefix, walking the sub directories.
+func (s *LocalStorage) List(prefix string) ([]StorageFile, error) {
+	var files []StorageFile
+	err := filepath.Walk(s.dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, file)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(rel); strings.HasPrefix(name, prefix) {
+			files = append(files, StorageFile{Name: name, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// Open returns the file to read.
+func (s *LocalStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+// Delete used to remove the file, and its parent directories left empty.
+func (s *LocalStorage) Delete(name string) error {
+	file := s.path(name)
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	for dir := filepath.Dir(file); dir != filepath.Clean(s.dir) && dir != "."; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalStorage) String() string {
+	return s.dir
+}
+
+// notExist returns an error satisfying os.IsNotExist.
+func notExist(op string, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SSL modes, the same as the mysql client.
+const (
+	SSLDisabled       = "disabled"
+	SSLPreferred      = "preferred"
+	SSLRequired       = "required"
+	SSLVerifyCA       = "verify-ca"
+	SSLVerifyIdentity = "verify-identity"
+)
+
+// TLSOptions holds the TLS and authentication options of the connections.
+type TLSOptions struct {
+	// Mode is one of the SSL modes, "" is verify-ca with CA, required with Cert, or else preferred.
+	Mode string
+	CA   string
+	Cert string
+	Key  string
+	// ServerPublicKey is the RSA public key file of the server, sent the password of caching_sha2_password
+	// and sha256_password without TLS, the driver requests it from the server if it's not set.
+	ServerPublicKey string
+	// AllowCleartextPassword used to allow the mysql_clear_password plugin, e.g. for PAM or LDAP accounts.
+	AllowCleartextPassword bool
+
+	name string
+}
+
+// Register used to check the options and register the tls config and the server public key of them
+// in the driver by name, for Apply.
+func (o *TLSOptions) Register(name string) error {
+	o.Mode = strings.Replace(strings.ToLower(o.Mode), "_", "-", -1)
+	if o.Mode == "" {
+		switch {
+		case o.CA != "":
+			o.Mode = SSLVerifyCA
+		case o.Cert != "":
+			o.Mode = SSLRequired
+		default:
+			o.Mode = SSLPreferred
+		}
+	}
+	if (o.Cert == "") != (o.Key == "") {
+		return errors.New("ssl cert and key must be set together")
+	}
+	switch o.Mode {
+	case SSLDisabled, SSLPreferred:
+		if o.CA != "" || o.Cert != "" {
+			return fmt.Errorf("ssl mode %s can't use a ca or cert, use required or higher", o.Mode)
+		}
+	case SSLRequired, SSLVerifyCA, SSLVerifyIdentity:
+		cfg, err := o.tlsConfig()
+		if err != nil {
+			return err
+		}
+		if err := mysql.RegisterTLSConfig(name, cfg); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown ssl mode %q, expect disabled, preferred, required, verify-ca or verify-identity", o.Mode)
+	}
+
+	if o.ServerPublicKey != "" {
+		key, err := readPublicKey(o.ServerPublicKey)
+		if err != nil {
+			return err
+		}
+		mysql.RegisterServerPubKey(name, key)
+	}
+	o.name = name
+	return nil
+}
+
+func (o *TLSOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	if o.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if o.CA != "" {
+		data, err := ioutil.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", o.CA)
+		}
+	}
+
+	switch o.Mode {
+	case SSLRequired:
+		cfg.InsecureSkipVerify = true
+	case SSLVerifyCA:
+		// verify the chain but not the host name.
+		cfg.InsecureSkipVerify = true
+		roots := cfg.RootCAs
+		cfg.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(raw))
+			for i, data := range raw {
+				cert, err := x509.ParseCertificate(data)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			if len(certs) == 0 {
+				return errors.New("no server certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(opts)
+			return err
+		}
+	}
+	return cfg, nil
+}
+
+// readPublicKey used to read the PEM encoded RSA public key, PKIX or PKCS#1.
+func readPublicKey(file string) (*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", file)
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a RSA public key", file)
+	}
+	return rsaKey, nil
+}
+
+// Apply used to set the registered options to the connection config.
+func (o *TLSOptions) Apply(cfg *mysql.Config) {
+	switch o.Mode {
+	case SSLDisabled:
+		cfg.TLSConfig = "false"
+	case SSLPreferred:
+		cfg.TLSConfig = "preferred"
+	default:
+		cfg.TLSConfig = o.name
+	}
+	if o.ServerPublicKey != "" {
+		cfg.ServerPubKey = o.name
+	}
+	cfg.AllowCleartextPasswords = o.AllowCleartextPassword
+}
+
+// ClientArgs returns the options of the mysql command line tools.
+func (o *TLSOptions) ClientArgs() []string {
+	args := []string{"--ssl-mode=" + strings.Replace(strings.ToUpper(o.Mode), "-", "_", -1)}
+	if o.CA != "" {
+		args = append(args, "--ssl-ca="+o.CA)
+	}
+	if o.Cert != "" {
+		args = append(args, "--ssl-cert="+o.Cert, "--ssl-key="+o.Key)
+	}
+	if o.ServerPublicKey != "" {
+		args = append(args, "--server-public-key-path="+o.ServerPublicKey)
+	}
+	if o.AllowCleartextPassword {
+		args = append(args, "--enable-cleartext-plugin")
+	}
+	return args
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// testCert returns a certificate of host signed by parent, self signed if parent is nil.
+func testCert(t *testing.T, host string, parent *tls.Certificate) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, interface{}(key)
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writePEM(t *testing.T, file, kind string, der []byte) string {
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// handshake returns the error of the client handshake with a server of cert.
+func handshake(cfg *tls.Config, cert tls.Certificate) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		s := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}})
+		s.Handshake()
+	}()
+	cfg = cfg.Clone()
+	cfg.ServerName = "db.example"
+	return tls.Client(client, cfg).Handshake()
+}
+
+func TestTLSModes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testCert(t, "ca", nil)
+	other := testCert(t, "other", nil)
+	caFile := writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Certificate[0])
+	servers := map[string]tls.Certificate{
+		"right host":  testCert(t, "db.example", &ca),
+		"wrong host":  testCert(t, "other.example", &ca),
+		"unknown ca":  testCert(t, "db.example", &other),
+		"self signed": other,
+	}
+	tests := []struct {
+		mode string
+		ok   []string
+	}{
+		{SSLRequired, []string{"right host", "wrong host", "unknown ca", "self signed"}},
+		{SSLVerifyCA, []string{"right host", "wrong host"}},
+		{SSLVerifyIdentity, []string{"right host"}},
+	}
+	for _, tt := range tests {
+		o := &TLSOptions{Mode: tt.mode, CA: caFile}
+		cfg, err := o.tlsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, cert := range servers {
+			want := false
+			for _, ok := range tt.ok {
+				want = want || ok == name
+			}
+			if err := handshake(cfg, cert); (err == nil) != want {
+				t.Errorf("%s with %s: handshake error %v, want ok %v", tt.mode, name, err, want)
+			}
+		}
+	}
+}
+
+func TestTLSRegister(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := testCert(t, "ca", nil)
+	caFile := writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.Certificate[0])
+	client := testCert(t, "client", &ca)
+	certFile := writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", client.Certificate[0])
+	keyFile := writePEM(t, filepath.Join(dir, "key.pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(client.PrivateKey.(*rsa.PrivateKey)))
+
+	tests := []struct {
+		opts TLSOptions
+		mode string
+		tls  string
+		err  bool
+	}{
+		{TLSOptions{}, SSLPreferred, "preferred", false},
+		{TLSOptions{Mode: "DISABLED"}, SSLDisabled, "false", false},
+		{TLSOptions{CA: caFile}, SSLVerifyCA, "test", false},
+		{TLSOptions{Cert: certFile, Key: keyFile}, SSLRequired, "test", false},
+		{TLSOptions{Mode: "VERIFY_IDENTITY", CA: caFile, Cert: certFile, Key: keyFile}, SSLVerifyIdentity, "test", false},
+		{TLSOptions{Cert: certFile}, "", "", true},
+		{TLSOptions{Mode: SSLPreferred, CA: caFile}, "", "", true},
+		{TLSOptions{Mode: "on"}, "", "", true},
+		{TLSOptions{Mode: SSLVerifyCA, CA: keyFile}, "", "", true},
+		{TLSOptions{Mode: SSLRequired, Cert: caFile, Key: keyFile}, "", "", true},
+	}
+	for i, tt := range tests {
+		o := tt.opts
+		err := o.Register("test")
+		if (err != nil) != tt.err {
+			t.Errorf("%d: Register error = %v, want error %v", i, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		cfg := mysql.NewConfig()
+		o.Apply(cfg)
+		if o.Mode != tt.mode || cfg.TLSConfig != tt.tls {
+			t.Errorf("%d: mode %s, tls %s, want %s, %s", i, o.Mode, cfg.TLSConfig, tt.mode, tt.tls)
+		}
+	}
+}
+
+func TestReadPublicKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		writePEM(t, filepath.Join(dir, "pkix.pem"), "PUBLIC KEY", der),
+		writePEM(t, filepath.Join(dir, "pkcs1.pem"), "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)),
+	} {
+		got, err := readPublicKey(file)
+		if err != nil || !reflect.DeepEqual(got, &key.PublicKey) {
+			t.Errorf("readPublicKey(%s) = %v", file, err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.pem"), []byte("not pem"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPublicKey(filepath.Join(dir, "bad.pem")); err == nil {
+		t.Errorf("readPublicKey of a bad file succeeded")
+	}
+}
+
+func TestTLSClientArgs(t *testing.T) {
+	o := &TLSOptions{Mode: SSLVerifyIdentity, CA: "ca.pem", Cert: "cert.pem", Key: "key.pem", ServerPublicKey: "pub.pem", AllowCleartextPassword: true}
+	want := []string{"--ssl-mode=VERIFY_IDENTITY", "--ssl-ca=ca.pem", "--ssl-cert=cert.pem", "--ssl-key=key.pem", "--server-public-key-path=pub.pem", "--enable-cleartext-plugin"}
+	if got := o.ClientArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ClientArgs = %v, want %v", got, want)
+	}
+}
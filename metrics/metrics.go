@@ -0,0 +1,259 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.001, .005, .01, .05, .1, .5, 1, 5, 10, 30, 60}
+
+// Sample is one value of a metric with its label values.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+type metric interface {
+	write(buf *bytes.Buffer)
+}
+
+// Registry holds all the metrics exposed by the handler.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry creates a new registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes all metrics in prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+	r.mu.Lock()
+	for _, m := range r.metrics {
+		m.write(&buf)
+	}
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// Serve used to listen on addr and expose the metrics on /metrics.
+func (r *Registry) Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	return http.ListenAndServe(addr, mux)
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) header(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+}
+
+func (d *desc) sample(buf *bytes.Buffer, name string, labels []string, extra string, v float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 || extra != "" {
+		pairs := make([]string, 0, len(labels)+1)
+		for i, l := range labels {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", d.labels[i], strconv.Quote(l)))
+		}
+		if extra != "" {
+			pairs = append(pairs, extra)
+		}
+		buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	buf.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+const labelSep = "\xff"
+
+// Vec is a counter or gauge with optional labels.
+type Vec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newVec(r *Registry, typ, name, help string, labels []string) *Vec {
+	v := &Vec{desc: desc{name: name, help: help, typ: typ, labels: labels}, values: make(map[string]float64)}
+	r.register(v)
+	return v
+}
+
+// NewCounter creates a counter, the value only goes up.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Vec {
+	return newVec(r, "counter", name, help, labels)
+}
+
+// NewGauge creates a gauge.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Vec {
+	return newVec(r, "gauge", name, help, labels)
+}
+
+// Add used to add delta to the value with the label values.
+func (v *Vec) Add(delta float64, labels ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[strings.Join(labels, labelSep)] += delta
+}
+
+// Inc used to add one.
+func (v *Vec) Inc(labels ...string) {
+	v.Add(1, labels...)
+}
+
+// Dec used to subtract one.
+func (v *Vec) Dec(labels ...string) {
+	v.Add(-1, labels...)
+}
+
+// Set used to set the value with the label values.
+func (v *Vec) Set(value float64, labels ...string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[strings.Join(labels, labelSep)] = value
+}
+
+func (v *Vec) write(buf *bytes.Buffer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.header(buf)
+	for _, k := range sortedKeys(v.values) {
+		v.sample(buf, v.name, splitLabels(k, len(v.labels)), "", v.values[k])
+	}
+}
+
+// Func is a counter or gauge whose samples are collected on every scrape.
+type Func struct {
+	desc
+	collect func() []Sample
+}
+
+// NewCounterFunc creates a counter collected by fn.
+func (r *Registry) NewCounterFunc(name, help string, fn func() []Sample, labels ...string) *Func {
+	f := &Func{desc: desc{name: name, help: help, typ: "counter", labels: labels}, collect: fn}
+	r.register(f)
+	return f
+}
+
+// NewGaugeFunc creates a gauge collected by fn.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() []Sample, labels ...string) *Func {
+	f := &Func{desc: desc{name: name, help: help, typ: "gauge", labels: labels}, collect: fn}
+	r.register(f)
+	return f
+}
+
+func (f *Func) write(buf *bytes.Buffer) {
+	f.header(buf)
+	for _, s := range f.collect() {
+		f.sample(buf, f.name, s.Labels, "", s.Value)
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+// NewHistogram creates a histogram with the upper bounds of buckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{desc: desc{name: name, help: help, typ: "histogram", labels: labels}, buckets: buckets, values: make(map[string]*histogramValue)}
+	r.register(h)
+	return h
+}
+
+// Observe used to add one observation.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labels, labelSep)
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			hv.counts[i]++
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+func (h *Histogram) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(buf)
+	keys := make([]string, 0, len(h.values))
+	for k := range h.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		hv := h.values[k]
+		labels := splitLabels(k, len(h.labels))
+		for i, b := range h.buckets {
+			h.sample(buf, h.name+"_bucket", labels, "le="+strconv.Quote(formatFloat(b)), float64(hv.counts[i]))
+		}
+		h.sample(buf, h.name+"_bucket", labels, `le="+Inf"`, float64(hv.count))
+		h.sample(buf, h.name+"_sum", labels, "", hv.sum)
+		h.sample(buf, h.name+"_count", labels, "", float64(hv.count))
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitLabels(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.SplitN(key, labelSep, n)
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	chunks := r.NewCounter("chunks_total", "Chunks.", "stage")
+	workers := r.NewGauge("workers", "Workers.")
+	query := r.NewHistogram("query_seconds", "Latency.", []float64{.1, 1}, "stage")
+	r.NewGaugeFunc("table_rows", "Rows per table.", func() []Sample {
+		return []Sample{{Labels: []string{`a"b`}, Value: 3}, {Labels: []string{"c"}, Value: math.Inf(1)}}
+	}, "table")
+	r.NewCounterFunc("events_total", "Events.", func() []Sample { return []Sample{{Value: 1.5}} })
+
+	chunks.Inc("restoring")
+	chunks.Add(2, "dumping")
+	workers.Inc()
+	workers.Inc()
+	workers.Dec()
+	query.Observe(.05, "dumping")
+	query.Observe(.5, "dumping")
+	query.Observe(5, "dumping")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+	want := `# HELP chunks_total Chunks.
+# TYPE chunks_total counter
+chunks_total{stage="dumping"} 2
+chunks_total{stage="restoring"} 1
+# HELP workers Workers.
+# TYPE workers gauge
+workers 1
+# HELP query_seconds Latency.
+# TYPE query_seconds histogram
+query_seconds_bucket{stage="dumping",le="0.1"} 1
+query_seconds_bucket{stage="dumping",le="1"} 2
+query_seconds_bucket{stage="dumping",le="+Inf"} 3
+query_seconds_sum{stage="dumping"} 5.55
+query_seconds_count{stage="dumping"} 3
+# HELP table_rows Rows per table.
+# TYPE table_rows gauge
+table_rows{table="a\"b"} 3
+table_rows{table="c"} +Inf
+# HELP events_total Events.
+# TYPE events_total counter
+events_total 1.5
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -28,7 +28,9 @@ pushd `pwd` &>/dev/null
 
 go get github.com/mitchellh/gox
 
-gox -output="result/mysqldump_{{.OS}}_{{.Arch}}" -ldflags="-s -w" ..
+VERSION=`git describe --tags --always`
+
+gox -output="result/mysqldump_{{.OS}}_{{.Arch}}" -ldflags="-s -w -X main.version=${VERSION}" ..
 
 cd result
 
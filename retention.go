@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+const (
+	// timestampLayout names the dump directories of '-timestamped'.
+	timestampLayout = "20060102-150405"
+	// latestName is the file in the root holding the name of the last complete dump.
+	latestName = "latest"
+)
+
+var timestampDir = regexp.MustCompile(`^\d{8}-\d{6}$`)
+
+// retention is the policy of the timestamped dumps, a dump kept by any rule is kept.
+type retention struct {
+	last    int
+	daily   int
+	weekly  int
+	monthly int
+}
+
+func (r *retention) empty() bool {
+	return r.last == 0 && r.daily == 0 && r.weekly == 0 && r.monthly == 0
+}
+
+// joinLocation returns the location of name in the dump root.
+func joinLocation(root string, name string) string {
+	if common.IsS3(root) {
+		return strings.TrimSuffix(root, "/") + "/" + name
+	}
+	return filepath.Join(root, name)
+}
+
+// listDumps returns the complete timestamped dumps of the root, newest first, and the incomplete ones.
+func listDumps(storage common.Storage) ([]time.Time, []string, error) {
+	files, err := storage.List("")
+	if err != nil {
+		return nil, nil, err
+	}
+	complete := map[string]bool{}
+	for _, f := range files {
+		parts := strings.SplitN(f.Name, "/", 2)
+		if len(parts) != 2 || !timestampDir.MatchString(parts[0]) {
+			continue
+		}
+		complete[parts[0]] = complete[parts[0]] || parts[1] == common.ManifestName
+	}
+
+	var dumps []time.Time
+	var incomplete []string
+	for name, ok := range complete {
+		t, err := time.ParseInLocation(timestampLayout, name, time.Local)
+		if err != nil {
+			continue
+		}
+		if ok {
+			dumps = append(dumps, t)
+		} else {
+			incomplete = append(incomplete, name)
+		}
+	}
+	sort.Slice(dumps, func(i, j int) bool { return dumps[i].After(dumps[j]) })
+	sort.Strings(incomplete)
+	return dumps, incomplete, nil
+}
+
+// keep returns the dumps to keep, the dumps are sorted newest first. The daily, weekly and
+// monthly rules keep the newest dump of each of the last N days, ISO weeks and months.
+func (r *retention) keep(dumps []time.Time) map[time.Time]bool {
+	kept := map[time.Time]bool{}
+	for i := 0; i < r.last && i < len(dumps); i++ {
+		kept[dumps[i]] = true
+	}
+	period := func(n int, key func(t time.Time) string) {
+		seen := map[string]bool{}
+		for _, t := range dumps {
+			if len(seen) >= n {
+				return
+			}
+			if k := key(t); !seen[k] {
+				seen[k] = true
+				kept[t] = true
+			}
+		}
+	}
+	period(r.daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	period(r.weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	period(r.monthly, func(t time.Time) string { return t.Format("2006-01") })
+	return kept
+}
+
+// keepAncestors used to add the dumps the kept incremental dumps start from to kept, an
+// incremental dump can't be loaded without the whole chain of its previous dumps.
+func keepAncestors(storage common.Storage, c *common.Cipher, dumps []time.Time, kept map[time.Time]bool) error {
+	byName := map[string]time.Time{}
+	var todo []string
+	for _, t := range dumps {
+		name := t.Format(timestampLayout)
+		byName[name] = t
+		if kept[t] {
+			todo = append(todo, name)
+		}
+	}
+	for len(todo) > 0 {
+		name := todo[0]
+		todo = todo[1:]
+		data, err := common.ReadAll(storage, name+"/"+common.ManifestName)
+		if err == nil {
+			data, err = c.Open(data)
+		}
+		manifest := &common.Manifest{}
+		if err == nil {
+			err = json.Unmarshal(data, manifest)
+		}
+		if err != nil {
+			return fmt.Errorf("dump %s: %s: %v", name, common.ManifestName, err)
+		}
+		if manifest.IncrementalFrom == "" {
+			continue
+		}
+		// the previous dump is only known by its location, a dump of another root is not ours to keep.
+		_, previous := common.SplitLocation(strings.TrimSuffix(manifest.IncrementalFrom, "/"))
+		if t, ok := byName[previous]; ok && !kept[t] {
+			kept[t] = true
+			todo = append(todo, previous)
+		}
+	}
+	return nil
+}
+
+// applyRetention used to delete the complete dumps of the root expired by the policy,
+// it's run after the new dump is complete, which is always kept with the dumps it's incremental from.
+func applyRetention(log *xlog.Log, storage common.Storage, c *common.Cipher, r *retention, current string) error {
+	dumps, incomplete, err := listDumps(storage)
+	if err != nil {
+		return err
+	}
+	for _, name := range incomplete {
+		if name != current {
+			log.Warning("retention.dump[%s].is.incomplete, left as is", name)
+		}
+	}
+
+	kept := r.keep(dumps)
+	for _, t := range dumps {
+		if t.Format(timestampLayout) == current {
+			kept[t] = true
+		}
+	}
+	if err := keepAncestors(storage, c, dumps, kept); err != nil {
+		return err
+	}
+	deleted := 0
+	for _, t := range dumps {
+		name := t.Format(timestampLayout)
+		if kept[t] {
+			continue
+		}
+		files, err := storage.List(name + "/")
+		if err != nil {
+			return err
+		}
+		// the manifest goes last, so a dump half deleted is still complete and deleted by the next run.
+		manifest := name + "/" + common.ManifestName
+		sort.SliceStable(files, func(i, j int) bool { return files[i].Name != manifest && files[j].Name == manifest })
+		for _, f := range files {
+			if err := storage.Delete(f.Name); err != nil {
+				return err
+			}
+		}
+		deleted++
+		log.Info("retention.dump[%s].deleted.files[%d]", name, len(files))
+	}
+	log.Info("retention.dumps[%d].deleted[%d]", len(dumps), deleted)
+	return nil
+}
+
+// resolveLatest returns the dump the 'latest' file of location points to, or location if it's not one.
+func resolveLatest(location string) string {
+	if location == "" || location == "-" || !strings.HasSuffix(location, latestName) {
+		return location
+	}
+	dir, name := common.SplitLocation(location)
+	if name != latestName {
+		return location
+	}
+	storage, err := openStorage(dir)
+	if err != nil {
+		return location
+	}
+	data, err := common.ReadAll(storage, latestName)
+	if err != nil {
+		return location
+	}
+	return joinLocation(dir, strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"mysqldump/common"
+	xlog "mysqldump/xlog"
+)
+
+func TestApplyRetention(t *testing.T) {
+	type dump struct {
+		name, from string
+	}
+	// a full dump each Monday and Thursday and the incremental dumps of the other days.
+	chains := []dump{
+		{"20261001-010000", ""},
+		{"20261002-010000", "ROOT/20261001-010000"},
+		{"20261003-010000", "ROOT/20261002-010000"},
+		{"20261004-010000", "ROOT/20261003-010000/"},
+		{"20261005-010000", ""},
+		{"20261006-010000", "ROOT/20261005-010000"},
+		{"20261007-010000", "/elsewhere/20260101-010000"},
+	}
+	tests := []struct {
+		name    string
+		policy  retention
+		current string
+		want    []string
+	}{
+		{
+			"the current dump keeps its chain",
+			retention{last: 1}, "20261006-010000",
+			[]string{"20261005-010000", "20261006-010000", "20261007-010000"},
+		},
+		{
+			"a kept dump keeps the whole chain",
+			retention{last: 1}, "20261004-010000",
+			[]string{"20261001-010000", "20261002-010000", "20261003-010000", "20261004-010000", "20261007-010000"},
+		},
+		{
+			"a dump of another root",
+			retention{monthly: 1}, "20261007-010000",
+			[]string{"20261007-010000"},
+		},
+		{
+			"the daily dumps",
+			retention{daily: 2}, "20261007-010000",
+			[]string{"20261005-010000", "20261006-010000", "20261007-010000"},
+		},
+	}
+	for _, tt := range tests {
+		root, err := ioutil.TempDir("", "retention")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		storage := common.NewLocalStorage(root)
+		for _, d := range chains {
+			dir := common.NewLocalStorage(filepath.Join(root, d.name))
+			if err := dir.Create("test-schema.sql", []byte("CREATE DATABASE test;\n")); err != nil {
+				t.Fatal(err)
+			}
+			m := common.NewManifest("test")
+			m.IncrementalFrom = filepath.FromSlash(replaceRoot(d.from, root))
+			if err := m.Write(dir, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// an incomplete dump is never deleted.
+		if err := storage.Create("20260901-010000/test-schema.sql", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		log := xlog.NewXLog(ioutil.Discard, xlog.Level(xlog.ERROR))
+		if err := applyRetention(log, storage, nil, &tt.policy, tt.current); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		dumps, incomplete, err := listDumps(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, d := range dumps {
+			got = append(got, d.Format(timestampLayout))
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(incomplete, []string{"20260901-010000"}) {
+			t.Errorf("%s: incomplete %v", tt.name, incomplete)
+		}
+	}
+}
+
+// replaceRoot used to point a location of the test chains to the dump root.
+func replaceRoot(location, root string) string {
+	if len(location) >= 4 && location[:4] == "ROOT" {
+		return root + location[4:]
+	}
+	return location
+}